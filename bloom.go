@@ -0,0 +1,72 @@
+package skiplist
+
+const bloomHashes = 4 // probes per key
+
+// bloom is a counting Bloom filter over the items of a skip list. Counters
+// rather than bits let deletes remove keys; a counter that saturates stays
+// set for good, which only costs false positives.
+type bloom struct {
+	hash     func(Item) uint64
+	counters []uint8
+	mask     uint64
+}
+
+// NewWithBloom creates a skip list keeping a counting Bloom filter of its
+// items, sized for about expected items, so that Search returns nil for most
+// absent keys without descending the list. hash must give equal items the
+// same value. The filter has no false negatives; its false positives, about
+// 1% at the expected size, grow as the list does, and they only cost the
+// usual descent. It takes a byte per 10 expected items and makes every insert
+// and delete hash the item.
+func NewWithBloom(hash func(Item) uint64, expected int) *SkipList {
+	m := uint64(64)
+	for m < uint64(expected)*10 {
+		m <<= 1
+	}
+	sl := New()
+	sl.bloom = &bloom{hash: hash, counters: make([]uint8, m), mask: m - 1}
+	return sl
+}
+
+// probe calls f with the counter index of each probe for item, derived from
+// one hash by double hashing.
+func (b *bloom) probe(item Item, f func(i uint64)) {
+	h := b.hash(item)
+	h1, h2 := h, h>>32|h<<32|1
+	for i := uint64(0); i < bloomHashes; i++ {
+		f((h1 + i*h2) & b.mask)
+	}
+}
+
+func (b *bloom) add(item Item) {
+	b.probe(item, func(i uint64) {
+		if b.counters[i] < 255 {
+			b.counters[i]++
+		}
+	})
+}
+
+func (b *bloom) remove(item Item) {
+	b.probe(item, func(i uint64) {
+		if c := b.counters[i]; c > 0 && c < 255 {
+			b.counters[i]--
+		}
+	})
+}
+
+func (b *bloom) mayContain(item Item) bool {
+	h := b.hash(item)
+	h1, h2 := h, h>>32|h<<32|1
+	for i := uint64(0); i < bloomHashes; i++ {
+		if b.counters[(h1+i*h2)&b.mask] == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *bloom) reset() {
+	for i := range b.counters {
+		b.counters[i] = 0
+	}
+}
@@ -0,0 +1,85 @@
+package skiplist
+
+import "testing"
+
+func hashInt(item Item) uint64 {
+	return uint64(item.(Int)) * 0x9E3779B97F4A7C15
+}
+
+func TestBloom(t *testing.T) {
+	sl := NewWithBloom(hashInt, 1000)
+	for _, v := range perm(1000) {
+		sl.Insert(v)
+	}
+	for _, v := range perm(1000) {
+		if sl.Search(v) != v {
+			t.Fatalf("Search(%v) missed a present item", v)
+		}
+	}
+	rejected := 0
+	for i := 1000; i < 11000; i++ {
+		if !sl.bloom.mayContain(Int(i)) {
+			rejected++
+		}
+		if sl.Search(Int(i)) != nil {
+			t.Fatalf("Search(%d) found an absent item", i)
+		}
+	}
+	if rejected < 9000 {
+		t.Fatalf("want most absent keys rejected by the filter, got %d of 10000", rejected)
+	}
+
+	// Deleted keys leave the filter.
+	for _, v := range rang(500) {
+		sl.Delete(v)
+	}
+	sl.Retain(Int(500), Int(899))
+	for _, v := range rang(1000) {
+		present := v.(Int) >= 500 && v.(Int) < 900
+		if got := sl.Search(v) != nil; got != present {
+			t.Fatalf("Search(%v): want found=%v", v, present)
+		}
+	}
+	for _, v := range rang(900)[500:] {
+		sl.Delete(v)
+	}
+	for i, c := range sl.bloom.counters {
+		if c != 0 {
+			t.Fatalf("emptied list: counter %d is %d", i, c)
+		}
+	}
+	sl.Replace([]Item{Int(1), Int(2)})
+	if sl.Search(Int(1)) != Int(1) || sl.Search(Int(600)) != nil {
+		t.Fatal("Replace should rebuild the filter")
+	}
+	sl.Replace(nil)
+	for i, c := range sl.bloom.counters {
+		if c != 0 {
+			t.Fatalf("empty list: counter %d is %d", i, c)
+		}
+	}
+}
+
+func BenchmarkSearchAbsent(b *testing.B) {
+	for _, filtered := range []bool{false, true} {
+		name := "plain"
+		sl := New()
+		if filtered {
+			name = "bloom"
+			sl = NewWithBloom(hashInt, benchmarkListSize)
+		}
+		b.Run(name, func(b *testing.B) {
+			for _, v := range perm(benchmarkListSize) {
+				sl.Insert(Int(2 * v.(Int)))
+			}
+			absent := make([]Item, benchmarkListSize)
+			for i := range absent {
+				absent[i] = Int(2*i + 1)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				sl.Search(absent[i%benchmarkListSize])
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package skiplist
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// ConcurrentSkipList is a skip list safe for concurrent use, after the lazy
+// skip list of Herlihy, Lev, Luchangco and Shavit. Searches take no lock and
+// never wait. Inserts and deletes lock only the nodes around the item, on
+// each of its levels, so writers on different parts of the list run in
+// parallel. Removed nodes are left to the garbage collector, which keeps
+// them alive while a concurrent search may still be on them.
+//
+// Unlike SkipList, it keeps no free list, shared or per goroutine. A search
+// takes no lock, so a node recycled from a free list could be cleared or
+// relinked elsewhere under a reader, and guarding against that would cost
+// every search an epoch or hazard pointer announcement. Each insert instead
+// allocates its node, which the Go allocator serves from per-processor
+// caches, so writers do not serialize on allocation. The price is memory:
+// removed nodes are held until the garbage collector frees them, rather than
+// being reused at once.
+type ConcurrentSkipList struct {
+	head   *cnode
+	level  atomic.Int32 // levels in use, only ever raised
+	length atomic.Int64
+}
+
+// cnode is a node of a ConcurrentSkipList. key orders it and never changes;
+// item is the item handed out, which Insert replaces by an equal one.
+type cnode struct {
+	key         Item
+	item        atomic.Pointer[Item]
+	next        []atomic.Pointer[cnode]
+	mu          sync.Mutex  // held to link or unlink the node after it
+	marked      atomic.Bool // being removed, set under mu
+	fullyLinked atomic.Bool // linked on all its levels
+}
+
+// NewConcurrent creates an empty ConcurrentSkipList.
+func NewConcurrent() *ConcurrentSkipList {
+	sl := &ConcurrentSkipList{
+		head: &cnode{next: make([]atomic.Pointer[cnode], DefaultMaxLevel)},
+	}
+	sl.level.Store(1)
+	return sl
+}
+
+// find fills preds and succs with the nodes before and from key on every
+// level in use, and returns the highest level where succs holds a node equal
+// to key, or -1.
+func (sl *ConcurrentSkipList) find(key Item, preds, succs []*cnode) int {
+	found := -1
+	pred := sl.head
+	for i := int(sl.level.Load()) - 1; i >= 0; i-- {
+		cur := pred.next[i].Load()
+		for cur != nil && cur.key.Less(key) {
+			pred, cur = cur, cur.next[i].Load()
+		}
+		if found == -1 && cur != nil && !key.Less(cur.key) {
+			found = i
+		}
+		preds[i], succs[i] = pred, cur
+	}
+	return found
+}
+
+// Search returns the item equal to key, or nil if there is none.
+func (sl *ConcurrentSkipList) Search(key Item) Item {
+	if key == nil {
+		panic(ErrNilItem)
+	}
+	pred := sl.head
+	for i := int(sl.level.Load()) - 1; i >= 0; i-- {
+		cur := pred.next[i].Load()
+		for cur != nil && cur.key.Less(key) {
+			pred, cur = cur, cur.next[i].Load()
+		}
+		if cur != nil && !key.Less(cur.key) {
+			if cur.fullyLinked.Load() && !cur.marked.Load() {
+				return *cur.item.Load()
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// Insert adds item, or replaces the item equal to it.
+func (sl *ConcurrentSkipList) Insert(item Item) {
+	if item == nil {
+		panic(ErrNilItem)
+	}
+	var predsAlloc, succsAlloc [DefaultMaxLevel]*cnode
+	preds, succs := predsAlloc[:], succsAlloc[:]
+	lvl := sl.randomLevel()
+	// raise the level first, so that find fills preds up to lvl
+	for {
+		l := sl.level.Load()
+		if l >= lvl || sl.level.CompareAndSwap(l, lvl) {
+			break
+		}
+	}
+	for {
+		if found := sl.find(item, preds, succs); found != -1 {
+			x := succs[found]
+			if x.marked.Load() {
+				runtime.Gosched() // being removed, wait until it is gone
+				continue
+			}
+			for !x.fullyLinked.Load() {
+				runtime.Gosched()
+			}
+			x.item.Store(&item)
+			return
+		}
+
+		locked, valid := -1, true
+		var prev *cnode
+		for i := 0; valid && i < int(lvl); i++ {
+			pred, succ := preds[i], succs[i]
+			if pred != prev {
+				pred.mu.Lock()
+				locked, prev = i, pred
+			}
+			valid = !pred.marked.Load() && (succ == nil || !succ.marked.Load()) && pred.next[i].Load() == succ
+		}
+		if !valid {
+			unlockPreds(preds, locked)
+			continue
+		}
+
+		x := &cnode{key: item, next: make([]atomic.Pointer[cnode], lvl)}
+		x.item.Store(&item)
+		for i := 0; i < int(lvl); i++ {
+			x.next[i].Store(succs[i])
+		}
+		for i := 0; i < int(lvl); i++ {
+			preds[i].next[i].Store(x)
+		}
+		x.fullyLinked.Store(true)
+		unlockPreds(preds, locked)
+		sl.length.Add(1)
+		return
+	}
+}
+
+// Delete removes the item equal to item and reports whether there was one.
+func (sl *ConcurrentSkipList) Delete(item Item) bool {
+	if item == nil {
+		panic(ErrNilItem)
+	}
+	var predsAlloc, succsAlloc [DefaultMaxLevel]*cnode
+	preds, succs := predsAlloc[:], succsAlloc[:]
+	var victim *cnode
+	for {
+		found := sl.find(item, preds, succs)
+		if victim == nil {
+			if found == -1 {
+				return false
+			}
+			x := succs[found]
+			// only a node fully linked and found on its top level is ready
+			if !x.fullyLinked.Load() || len(x.next)-1 != found || x.marked.Load() {
+				return false
+			}
+			x.mu.Lock()
+			if x.marked.Load() {
+				x.mu.Unlock()
+				return false
+			}
+			x.marked.Store(true)
+			victim = x
+		}
+
+		locked, valid := -1, true
+		var prev *cnode
+		for i := 0; valid && i < len(victim.next); i++ {
+			pred := preds[i]
+			if pred != prev {
+				pred.mu.Lock()
+				locked, prev = i, pred
+			}
+			valid = !pred.marked.Load() && pred.next[i].Load() == victim
+		}
+		if !valid {
+			unlockPreds(preds, locked)
+			continue
+		}
+
+		for i := len(victim.next) - 1; i >= 0; i-- {
+			preds[i].next[i].Store(victim.next[i].Load())
+		}
+		victim.mu.Unlock()
+		unlockPreds(preds, locked)
+		sl.length.Add(-1)
+		return true
+	}
+}
+
+// unlockPreds unlocks the distinct nodes of preds[:locked+1], locked in
+// order of level. A node that is the predecessor on several levels holds
+// them consecutively and is unlocked once.
+func unlockPreds(preds []*cnode, locked int) {
+	var prev *cnode
+	for i := 0; i <= locked; i++ {
+		if preds[i] != prev {
+			preds[i].mu.Unlock()
+			prev = preds[i]
+		}
+	}
+}
+
+// Len returns the number of items.
+func (sl *ConcurrentSkipList) Len() int {
+	return int(sl.length.Load())
+}
+
+// ForEach calls f for each item in ascending order. It sees every item
+// present throughout the call, and may or may not see those inserted or
+// deleted meanwhile.
+func (sl *ConcurrentSkipList) ForEach(f func(item Item)) {
+	for x := sl.head.next[0].Load(); x != nil; x = x.next[0].Load() {
+		if x.fullyLinked.Load() && !x.marked.Load() {
+			f(*x.item.Load())
+		}
+	}
+}
+
+// Validate checks the invariants of the list like SkipList.Validate. It
+// must not run concurrently with inserts or deletes.
+func (sl *ConcurrentSkipList) Validate() error {
+	level := int(sl.level.Load())
+	onLevel := make([]map[*cnode]bool, level)
+	for i := range onLevel {
+		onLevel[i] = map[*cnode]bool{}
+		var prev *cnode
+		for x := sl.head.next[i].Load(); x != nil; prev, x = x, x.next[i].Load() {
+			switch {
+			case len(x.next) <= i:
+				return fmt.Errorf("%w: node %v linked above its height", ErrCorrupt, x.key)
+			case x.marked.Load() || !x.fullyLinked.Load():
+				return fmt.Errorf("%w: node %v linked while not in the list", ErrCorrupt, x.key)
+			case prev != nil && !prev.key.Less(x.key):
+				return fmt.Errorf("%w: %v out of order after %v at level %d", ErrCorrupt, x.key, prev.key, i)
+			case i > 0 && !onLevel[i-1][x]:
+				return fmt.Errorf("%w: node %v at level %d missing below", ErrCorrupt, x.key, i)
+			}
+			onLevel[i][x] = true
+		}
+	}
+	for x := range onLevel[0] {
+		if len(x.next) > level || len(x.next) > 1 && !onLevel[len(x.next)-1][x] {
+			return fmt.Errorf("%w: node %v missing from its top level", ErrCorrupt, x.key)
+		}
+	}
+	if n := len(onLevel[0]); n != sl.Len() {
+		return fmt.Errorf("%w: length %d but %d nodes", ErrCorrupt, sl.Len(), n)
+	}
+	return nil
+}
+
+func (sl *ConcurrentSkipList) randomLevel() int32 {
+	lvl := int32(1)
+	for lvl < DefaultMaxLevel && float32(rand.Uint32()&0xFFFF) < DefaultP*0xFFFF {
+		lvl++
+	}
+	return lvl
+}
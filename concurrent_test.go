@@ -0,0 +1,242 @@
+package skiplist
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestConcurrentSkipList(t *testing.T) {
+	sl := NewConcurrent()
+	for _, v := range rand.Perm(100) {
+		sl.Insert(kv{v, v})
+	}
+	sl.Insert(kv{7, 70})
+	if sl.Len() != 100 {
+		t.Fatalf("len: want 100, got %d", sl.Len())
+	}
+	if got := sl.Search(kv{key: 7}); got != (kv{7, 70}) {
+		t.Fatalf("Insert should replace the equal item, got %v", got)
+	}
+	for i := 0; i < 100; i += 2 {
+		if !sl.Delete(kv{key: i}) {
+			t.Fatalf("Delete(%d) missed", i)
+		}
+	}
+	if sl.Delete(kv{key: 0}) || sl.Search(kv{key: 0}) != nil || sl.Len() != 50 {
+		t.Fatal("deleted items should be gone")
+	}
+	var got []int
+	sl.ForEach(func(item Item) {
+		got = append(got, item.(kv).key)
+	})
+	for i, v := range got {
+		if v != 2*i+1 {
+			t.Fatalf("ForEach: want odd keys in order, got %v", got)
+		}
+	}
+	if err := sl.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConcurrentSkipListParallel(t *testing.T) {
+	const goroutines, n = 8, 2000
+	sl := NewConcurrent()
+	parallel := func(f func(g int)) {
+		var wg sync.WaitGroup
+		for g := 0; g < goroutines; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				f(g)
+			}(g)
+		}
+		wg.Wait()
+	}
+
+	// every goroutine inserts every key
+	parallel(func(g int) {
+		for _, v := range perm(n) {
+			sl.Insert(v)
+		}
+	})
+	if sl.Len() != n {
+		t.Fatalf("len: want %d, got %d", n, sl.Len())
+	}
+	if err := sl.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// every goroutine deletes every even key, while odd ones stay visible
+	var deleted [goroutines]int
+	parallel(func(g int) {
+		for _, v := range perm(n) {
+			if v.(Int)%2 == 0 {
+				if sl.Delete(v) {
+					deleted[g]++
+				}
+			} else if sl.Search(v) != v {
+				t.Errorf("Search(%v) missed an item present throughout", v)
+			}
+		}
+	})
+	total := 0
+	for _, d := range deleted {
+		total += d
+	}
+	if total != n/2 {
+		t.Fatalf("want every even key deleted once, got %d deletes", total)
+	}
+	if err := sl.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	var got []Item
+	sl.ForEach(func(item Item) { got = append(got, item) })
+	if len(got) != n/2 || sl.Len() != n/2 {
+		t.Fatalf("want %d items left, got %d, len %d", n/2, len(got), sl.Len())
+	}
+	for i, v := range got {
+		if v != Int(2*i+1) {
+			t.Fatalf("want the odd keys left, got %v at %d", v, i)
+		}
+	}
+}
+
+// TestConcurrentSkipListReclaim checks that readers running alongside
+// deletes never see a node cleared or reused: a removed node keeps its key
+// and item, and still leads forward to greater keys, until the garbage
+// collector frees it once no reader holds it.
+func TestConcurrentSkipListReclaim(t *testing.T) {
+	const readers, writers, n, rounds = 4, 4, 256, 50
+	sl := NewConcurrent()
+	for i := 0; i < n; i++ {
+		sl.Insert(kv{i, i * 10})
+	}
+	check := func(x *cnode) {
+		k := x.key.(kv)
+		if k.value != k.key*10 || *x.item.Load() != k {
+			t.Errorf("node of key %d holds key %v and item %v", k.key, k, *x.item.Load())
+		}
+	}
+
+	// hold every node as first linked, all of which get deleted below
+	var held []*cnode
+	for x := sl.head.next[0].Load(); x != nil; x = x.next[0].Load() {
+		held = append(held, x)
+	}
+
+	var wg sync.WaitGroup
+	var done atomic.Bool
+	for g := 0; g < writers; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(g)))
+			for i := 0; i < rounds*n/writers; i++ {
+				k := r.Intn(n)
+				sl.Delete(kv{key: k})
+				sl.Insert(kv{k, k * 10})
+			}
+		}(g)
+	}
+	var rg sync.WaitGroup
+	for g := 0; g < readers; g++ {
+		rg.Add(1)
+		go func(g int) {
+			defer rg.Done()
+			r := rand.New(rand.NewSource(int64(writers + g)))
+			for !done.Load() {
+				prev := -1
+				for x := sl.head.next[0].Load(); x != nil; x = x.next[0].Load() {
+					check(x)
+					k := x.key.(kv).key
+					if k <= prev {
+						t.Errorf("walk went from key %d back to %d", prev, k)
+						return
+					}
+					prev = k
+				}
+				k := r.Intn(n)
+				if got := sl.Search(kv{key: k}); got != nil && got != (kv{k, k * 10}) {
+					t.Errorf("Search(%d) = %v", k, got)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	done.Store(true)
+	rg.Wait()
+
+	for i := 0; i < n; i++ {
+		sl.Delete(kv{key: i})
+	}
+	for _, x := range held {
+		check(x)
+	}
+	if sl.Len() != 0 {
+		t.Fatalf("len: want 0, got %d", sl.Len())
+	}
+}
+
+func BenchmarkConcurrentSearch(b *testing.B) {
+	sl := NewConcurrent()
+	locked := struct {
+		sync.RWMutex
+		*SkipList
+	}{SkipList: New()}
+	for _, v := range perm(benchmarkListSize) {
+		sl.Insert(v)
+		locked.Insert(v)
+	}
+	keys := perm(benchmarkListSize)
+	b.Run("concurrent", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				sl.Search(keys[i%benchmarkListSize])
+			}
+		})
+	})
+	b.Run("rwmutex", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				locked.RLock()
+				locked.Search(keys[i%benchmarkListSize])
+				locked.RUnlock()
+			}
+		})
+	})
+}
+
+// BenchmarkConcurrentWrite runs random inserts and deletes from parallel
+// goroutines, either all over one shared key range or each over a range of
+// its own, on a list kept about half full.
+func BenchmarkConcurrentWrite(b *testing.B) {
+	for _, shared := range []bool{true, false} {
+		name := "sharded"
+		if shared {
+			name = "shared"
+		}
+		b.Run(name, func(b *testing.B) {
+			sl := NewConcurrent()
+			var shard atomic.Int64
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				base := 0
+				if !shared {
+					base = int(shard.Add(1)) * benchmarkListSize
+				}
+				r := rand.New(rand.NewSource(int64(base)))
+				for pb.Next() {
+					key := Int(base + r.Intn(benchmarkListSize))
+					if r.Intn(2) == 0 {
+						sl.Insert(key)
+					} else {
+						sl.Delete(key)
+					}
+				}
+			})
+		})
+	}
+}
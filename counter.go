@@ -0,0 +1,84 @@
+package skiplist
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// ConcurrentCounter holds an int64 count per key and is safe for concurrent
+// use. Incrementing an existing key and reading a count share a read lock
+// and update the count atomically, so they run in parallel; only adding a
+// new key takes the write lock.
+type ConcurrentCounter struct {
+	mu sync.RWMutex
+	sl *SkipList
+}
+
+// counterItem is the item of a ConcurrentCounter, ordered by key.
+type counterItem struct {
+	key   Item
+	count *int64
+}
+
+func (a counterItem) Less(b Item) bool {
+	return a.key.Less(b.(counterItem).key)
+}
+
+// NewConcurrentCounter creates an empty ConcurrentCounter.
+func NewConcurrentCounter() *ConcurrentCounter {
+	return &ConcurrentCounter{sl: New()}
+}
+
+// Incr adds delta to the count of key, starting from 0 for a new key, and
+// returns the new count.
+func (c *ConcurrentCounter) Incr(key Item, delta int64) int64 {
+	c.mu.RLock()
+	count := c.find(key)
+	c.mu.RUnlock()
+	if count == nil {
+		c.mu.Lock()
+		if count = c.find(key); count == nil {
+			count = new(int64)
+			c.sl.Insert(counterItem{key: key, count: count})
+		}
+		c.mu.Unlock()
+	}
+	return atomic.AddInt64(count, delta)
+}
+
+// Get returns the count of key, 0 if it was never incremented.
+func (c *ConcurrentCounter) Get(key Item) int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if count := c.find(key); count != nil {
+		return atomic.LoadInt64(count)
+	}
+	return 0
+}
+
+// Len returns the number of keys.
+func (c *ConcurrentCounter) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.sl.Len()
+}
+
+// ForEach calls f with each key and its count, in ascending order of keys.
+// New keys wait until it returns, and f must not add any.
+func (c *ConcurrentCounter) ForEach(f func(key Item, count int64)) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for it := c.sl.NewIterator(); it.Valid(); it.Next() {
+		item := it.Value().(counterItem)
+		f(item.key, atomic.LoadInt64(item.count))
+	}
+}
+
+// find returns the count of key, or nil if key is absent. The caller holds
+// the lock.
+func (c *ConcurrentCounter) find(key Item) *int64 {
+	if item := c.sl.Search(counterItem{key: key}); item != nil {
+		return item.(counterItem).count
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package skiplist
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestConcurrentCounter(t *testing.T) {
+	const (
+		goroutines = 16
+		rounds     = 1000
+		keys       = 50
+	)
+	c := NewConcurrentCounter()
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				c.Incr(Int((g+i)%keys), 1)
+				c.Incr(Int(i%keys), 2)
+				c.Get(Int(i % keys))
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if c.Len() != keys {
+		t.Fatalf("want %d keys, got %d", keys, c.Len())
+	}
+	var total int64
+	prev := Int(-1)
+	c.ForEach(func(key Item, count int64) {
+		if !prev.Less(key) {
+			t.Fatalf("keys out of order: %v after %v", key, prev)
+		}
+		prev = key.(Int)
+		total += count
+	})
+	if want := int64(goroutines * rounds * 3); total != want {
+		t.Fatalf("want a total of %d, got %d", want, total)
+	}
+	// Every goroutine adds 2 to each key rounds/keys times, and 1 as often.
+	for k := 0; k < keys; k++ {
+		if got, want := c.Get(Int(k)), int64(goroutines*rounds/keys*3); got != want {
+			t.Fatalf("key %d: want %d, got %d", k, want, got)
+		}
+	}
+	if c.Get(Int(keys)) != 0 {
+		t.Fatal("want 0 for an absent key")
+	}
+}
@@ -0,0 +1,169 @@
+package skiplist
+
+import (
+	"cmp"
+	"math/rand"
+	"time"
+)
+
+// SkipListG is a skip list mapping ordered keys of type K to values of type
+// V. Keys are compared with < directly, so unlike SkipList it needs no Item
+// type, boxes neither keys nor values, and makes no type assertion per
+// comparison. Float keys must not be NaN.
+type SkipListG[K cmp.Ordered, V any] struct {
+	header nodeG[K, V]
+	level  int32 // current max level
+	length int
+	random *rand.Rand
+}
+
+type nodeG[K cmp.Ordered, V any] struct {
+	key     K
+	value   V
+	forward []*nodeG[K, V]
+}
+
+// NewG creates an empty generic skip list.
+func NewG[K cmp.Ordered, V any]() *SkipListG[K, V] {
+	return &SkipListG[K, V]{
+		header: nodeG[K, V]{forward: make([]*nodeG[K, V], DefaultMaxLevel)},
+		level:  1,
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Insert sets the value of key, replacing the value of an equal key.
+func (sl *SkipListG[K, V]) Insert(key K, value V) {
+	var prev [DefaultMaxLevel]*nodeG[K, V]
+	x := &sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for y := x.forward[i]; y != nil && y.key < key; y = x.forward[i] {
+			x = y
+		}
+		prev[i] = x
+	}
+	if y := x.forward[0]; y != nil && y.key == key {
+		y.value = value
+		return
+	}
+	lvl := sl.randomLevel()
+	for ; sl.level < lvl; sl.level++ {
+		prev[sl.level] = &sl.header
+	}
+	x = &nodeG[K, V]{key: key, value: value, forward: make([]*nodeG[K, V], lvl)}
+	for i := int32(0); i < lvl; i++ {
+		x.forward[i], prev[i].forward[i] = prev[i].forward[i], x
+	}
+	sl.length++
+}
+
+// Search returns the value of key and whether key is present.
+func (sl *SkipListG[K, V]) Search(key K) (V, bool) {
+	if x := sl.ceil(key); x != nil && x.key == key {
+		return x.value, true
+	}
+	var zero V
+	return zero, false
+}
+
+// Delete removes key and reports whether it was present.
+func (sl *SkipListG[K, V]) Delete(key K) bool {
+	var prev [DefaultMaxLevel]*nodeG[K, V]
+	x := &sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for y := x.forward[i]; y != nil && y.key < key; y = x.forward[i] {
+			x = y
+		}
+		prev[i] = x
+	}
+	x = x.forward[0]
+	if x == nil || x.key != key {
+		return false
+	}
+	for i := range x.forward {
+		prev[i].forward[i] = x.forward[i]
+	}
+	for sl.level > 1 && sl.header.forward[sl.level-1] == nil {
+		sl.level--
+	}
+	sl.length--
+	return true
+}
+
+// Len returns the number of keys.
+func (sl *SkipListG[K, V]) Len() int {
+	return sl.length
+}
+
+// ceil returns the first node whose key is not less than key, or nil.
+func (sl *SkipListG[K, V]) ceil(key K) *nodeG[K, V] {
+	x := &sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for y := x.forward[i]; y != nil && y.key < key; y = x.forward[i] {
+			x = y
+		}
+	}
+	return x.forward[0]
+}
+
+func (sl *SkipListG[K, V]) randomLevel() int32 {
+	lvl := int32(1)
+	for lvl < DefaultMaxLevel && float32(sl.random.Uint32()&0xFFFF) < DefaultP*0xFFFF {
+		lvl++
+	}
+	return lvl
+}
+
+// NewIterator returns an iterator at the first key.
+func (sl *SkipListG[K, V]) NewIterator() *IteratorG[K, V] {
+	return &IteratorG[K, V]{sl: sl, x: sl.header.forward[0]}
+}
+
+// IteratorG walks the keys of a SkipListG in ascending order.
+type IteratorG[K cmp.Ordered, V any] struct {
+	sl *SkipListG[K, V]
+	x  *nodeG[K, V]
+}
+
+func (it *IteratorG[K, V]) Valid() bool {
+	return it.x != nil
+}
+
+func (it *IteratorG[K, V]) Next() {
+	it.x = it.x.forward[0]
+}
+
+func (it *IteratorG[K, V]) Key() K {
+	return it.x.key
+}
+
+func (it *IteratorG[K, V]) Value() V {
+	return it.x.value
+}
+
+// MoveTo moves the iterator to the first key not less than key.
+func (it *IteratorG[K, V]) MoveTo(key K) {
+	it.x = it.sl.ceil(key)
+}
+
+// ToItemList returns a SkipList holding wrap(key, value) for each pair, to
+// move data to the Item API. The items are inserted, so equal ones replace
+// each other and their order need not follow that of the keys.
+func (sl *SkipListG[K, V]) ToItemList(wrap func(key K, value V) Item) *SkipList {
+	l := New()
+	for x := sl.header.forward[0]; x != nil; x = x.forward[0] {
+		l.Insert(wrap(x.key, x.value))
+	}
+	return l
+}
+
+// FromItemList returns a SkipListG holding the pairs unwrap returns for the
+// items of sl, to move data from the Item API. Pairs with equal keys replace
+// each other in the order of the items.
+func FromItemList[K cmp.Ordered, V any](sl *SkipList, unwrap func(item Item) (K, V)) *SkipListG[K, V] {
+	g := NewG[K, V]()
+	for it := sl.NewIterator(); it.Valid(); it.Next() {
+		g.Insert(unwrap(it.Value()))
+	}
+	return g
+}
@@ -0,0 +1,110 @@
+package skiplist
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestSkipListG(t *testing.T) {
+	sl := NewG[int, string]()
+	for _, v := range rand.Perm(100) {
+		sl.Insert(v, "x")
+	}
+	sl.Insert(7, "seven")
+	if sl.Len() != 100 {
+		t.Fatalf("len: want 100, got %d", sl.Len())
+	}
+	if v, ok := sl.Search(7); !ok || v != "seven" {
+		t.Fatalf("Search(7): want seven, true, got %q, %v", v, ok)
+	}
+	if v, ok := sl.Search(100); ok || v != "" {
+		t.Fatalf("Search(100): want the zero value, false, got %q, %v", v, ok)
+	}
+	for i := 0; i < 100; i += 2 {
+		if !sl.Delete(i) {
+			t.Fatalf("Delete(%d) missed", i)
+		}
+	}
+	if sl.Delete(0) || sl.Len() != 50 {
+		t.Fatal("Delete of an absent key should report false")
+	}
+	want := 1
+	for it := sl.NewIterator(); it.Valid(); it.Next() {
+		if it.Key() != want {
+			t.Fatalf("want key %d, got %d", want, it.Key())
+		}
+		want += 2
+	}
+	it := sl.NewIterator()
+	it.MoveTo(50)
+	if !it.Valid() || it.Key() != 51 {
+		t.Fatal("MoveTo(50): want 51")
+	}
+
+	s := NewG[string, int]()
+	for i, k := range []string{"pear", "apple", "fig"} {
+		s.Insert(k, i)
+	}
+	if it := s.NewIterator(); it.Key() != "apple" || it.Value() != 1 {
+		t.Fatalf("want apple first, got %q", it.Key())
+	}
+}
+
+func BenchmarkSearchG(b *testing.B) {
+	keys := rand.Perm(benchmarkListSize)
+	b.Run("generic", func(b *testing.B) {
+		sl := NewG[int, int]()
+		for _, k := range keys {
+			sl.Insert(k, k)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			sl.Search(keys[i%benchmarkListSize])
+		}
+	})
+	b.Run("item", func(b *testing.B) {
+		sl := New()
+		items := make([]Item, len(keys))
+		for i, k := range keys {
+			items[i] = Int(k)
+			sl.Insert(items[i])
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			sl.Search(items[i%benchmarkListSize])
+		}
+	})
+}
+
+func TestItemListConversion(t *testing.T) {
+	g := NewG[int, string]()
+	for _, v := range rand.Perm(100) {
+		g.Insert(v, strconv.Itoa(v))
+	}
+	sl := g.ToItemList(func(key int, value string) Item { return kv{key, len(value)} })
+	if sl.Len() != 100 {
+		t.Fatalf("item list len: want 100, got %d", sl.Len())
+	}
+	i := 0
+	for it := sl.NewIterator(); it.Valid(); it.Next() {
+		if want := (kv{i, len(strconv.Itoa(i))}); it.Value() != want {
+			t.Fatalf("item %d: want %v, got %v", i, want, it.Value())
+		}
+		i++
+	}
+
+	back := FromItemList(sl, func(item Item) (int, string) {
+		return item.(kv).key, strconv.Itoa(item.(kv).key)
+	})
+	if back.Len() != 100 {
+		t.Fatalf("generic list len: want 100, got %d", back.Len())
+	}
+	i = 0
+	for it := back.NewIterator(); it.Valid(); it.Next() {
+		if it.Key() != i || it.Value() != strconv.Itoa(i) {
+			t.Fatalf("pair %d: got %d => %q", i, it.Key(), it.Value())
+		}
+		i++
+	}
+}
@@ -0,0 +1,181 @@
+package skiplist
+
+import (
+	"math/rand"
+	"time"
+)
+
+// IntSet is an ordered set of ints. It runs the skip list algorithm on nodes
+// holding the ints themselves, saving the boxing of a SkipList of Int items
+// when only membership and order matter.
+type IntSet struct {
+	header intNode
+	level  int32 // current max level
+	length int
+	random *rand.Rand
+}
+
+type intNode struct {
+	key     int
+	forward []*intNode
+}
+
+// NewIntSet creates an empty int set.
+func NewIntSet() *IntSet {
+	return &IntSet{
+		header: intNode{forward: make([]*intNode, DefaultMaxLevel)},
+		level:  1,
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Add inserts key and reports whether it was not already present.
+func (s *IntSet) Add(key int) bool {
+	var prev [DefaultMaxLevel]*intNode
+	x := &s.header
+	for i := s.level - 1; i >= 0; i-- {
+		for y := x.forward[i]; y != nil && y.key < key; y = x.forward[i] {
+			x = y
+		}
+		prev[i] = x
+	}
+	if y := x.forward[0]; y != nil && y.key == key {
+		return false
+	}
+	lvl := s.randomLevel()
+	for ; s.level < lvl; s.level++ {
+		prev[s.level] = &s.header
+	}
+	x = &intNode{key: key, forward: make([]*intNode, lvl)}
+	for i := int32(0); i < lvl; i++ {
+		x.forward[i], prev[i].forward[i] = prev[i].forward[i], x
+	}
+	s.length++
+	return true
+}
+
+// Has reports whether key is in the set.
+func (s *IntSet) Has(key int) bool {
+	x := &s.header
+	for i := s.level - 1; i >= 0; i-- {
+		for y := x.forward[i]; y != nil && y.key < key; y = x.forward[i] {
+			x = y
+		}
+	}
+	x = x.forward[0]
+	return x != nil && x.key == key
+}
+
+// Remove deletes key and reports whether it was present.
+func (s *IntSet) Remove(key int) bool {
+	var prev [DefaultMaxLevel]*intNode
+	x := &s.header
+	for i := s.level - 1; i >= 0; i-- {
+		for y := x.forward[i]; y != nil && y.key < key; y = x.forward[i] {
+			x = y
+		}
+		prev[i] = x
+	}
+	x = x.forward[0]
+	if x == nil || x.key != key {
+		return false
+	}
+	for i := range x.forward {
+		prev[i].forward[i] = x.forward[i]
+	}
+	for s.level > 1 && s.header.forward[s.level-1] == nil {
+		s.level--
+	}
+	s.length--
+	return true
+}
+
+// Len returns the number of ints in the set.
+func (s *IntSet) Len() int {
+	return s.length
+}
+
+// ForEach calls f for each int of the set in ascending order.
+func (s *IntSet) ForEach(f func(key int)) {
+	for x := s.header.forward[0]; x != nil; x = x.forward[0] {
+		f(x.key)
+	}
+}
+
+// Ints returns the ints of the set in ascending order.
+func (s *IntSet) Ints() []int {
+	keys := make([]int, 0, s.length)
+	s.ForEach(func(key int) {
+		keys = append(keys, key)
+	})
+	return keys
+}
+
+// Union returns a new set of the ints in s or t.
+func (s *IntSet) Union(t *IntSet) *IntSet {
+	return s.merge(t, true, true, true)
+}
+
+// Intersect returns a new set of the ints in both s and t.
+func (s *IntSet) Intersect(t *IntSet) *IntSet {
+	return s.merge(t, false, true, false)
+}
+
+// Difference returns a new set of the ints in s but not in t.
+func (s *IntSet) Difference(t *IntSet) *IntSet {
+	return s.merge(t, true, false, false)
+}
+
+// merge walks s and t together and builds a set of the ints found only in
+// s, in both, or only in t, as selected by the flags.
+func (s *IntSet) merge(t *IntSet, onlyS, both, onlyT bool) *IntSet {
+	out := NewIntSet()
+	var tail [DefaultMaxLevel]*intNode
+	for i := range tail {
+		tail[i] = &out.header
+	}
+	x, y := s.header.forward[0], t.header.forward[0]
+	for x != nil || y != nil {
+		switch {
+		case y == nil || x != nil && x.key < y.key:
+			if onlyS {
+				out.pushBack(tail[:], x.key)
+			}
+			x = x.forward[0]
+		case x == nil || y.key < x.key:
+			if onlyT {
+				out.pushBack(tail[:], y.key)
+			}
+			y = y.forward[0]
+		default:
+			if both {
+				out.pushBack(tail[:], x.key)
+			}
+			x, y = x.forward[0], y.forward[0]
+		}
+	}
+	return out
+}
+
+// pushBack appends key, greater than every int of the set. tail holds the
+// last node of every level and is advanced to the new node.
+func (s *IntSet) pushBack(tail []*intNode, key int) {
+	lvl := s.randomLevel()
+	if lvl > s.level {
+		s.level = lvl
+	}
+	x := &intNode{key: key, forward: make([]*intNode, lvl)}
+	for i := int32(0); i < lvl; i++ {
+		tail[i].forward[i] = x
+		tail[i] = x
+	}
+	s.length++
+}
+
+func (s *IntSet) randomLevel() int32 {
+	lvl := int32(1)
+	for lvl < DefaultMaxLevel && float32(s.random.Uint32()&0xFFFF) < DefaultP*0xFFFF {
+		lvl++
+	}
+	return lvl
+}
@@ -0,0 +1,75 @@
+package skiplist
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestIntSet(t *testing.T) {
+	s := NewIntSet()
+	for _, v := range rand.Perm(1000) {
+		if !s.Add(v) {
+			t.Fatalf("Add(%d) reported a duplicate", v)
+		}
+	}
+	if s.Add(500) {
+		t.Fatal("Add of a present key should report false")
+	}
+	if s.Len() != 1000 {
+		t.Fatalf("len: want 1000, got %d", s.Len())
+	}
+	for _, v := range rand.Perm(1000) {
+		if v%2 == 1 && !s.Remove(v) {
+			t.Fatalf("Remove(%d) missed", v)
+		}
+	}
+	if s.Remove(1) {
+		t.Fatal("Remove of an absent key should report false")
+	}
+	for v := -1; v <= 1000; v++ {
+		if want := v >= 0 && v < 1000 && v%2 == 0; s.Has(v) != want {
+			t.Fatalf("Has(%d): want %v", v, want)
+		}
+	}
+	keys := s.Ints()
+	if len(keys) != 500 {
+		t.Fatalf("want 500 keys, got %d", len(keys))
+	}
+	for i, v := range keys {
+		if v != 2*i {
+			t.Fatalf("key %d: want %d, got %d", i, 2*i, v)
+		}
+	}
+}
+
+func TestIntSetOperations(t *testing.T) {
+	a, b := NewIntSet(), NewIntSet()
+	for _, v := range []int{5, 1, 3, 7} {
+		a.Add(v)
+	}
+	for _, v := range []int{4, 3, 5, 6} {
+		b.Add(v)
+	}
+	for _, tc := range []struct {
+		name string
+		set  *IntSet
+		want []int
+	}{
+		{"union", a.Union(b), []int{1, 3, 4, 5, 6, 7}},
+		{"intersect", a.Intersect(b), []int{3, 5}},
+		{"difference", a.Difference(b), []int{1, 7}},
+	} {
+		if got := tc.set.Ints(); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: want %v, got %v", tc.name, tc.want, got)
+		}
+		if tc.set.Len() != len(tc.want) {
+			t.Errorf("%s: len: want %d, got %d", tc.name, len(tc.want), tc.set.Len())
+		}
+	}
+	u := a.Union(b)
+	u.Add(2)
+	if !u.Has(2) || !u.Has(7) || u.Has(8) {
+		t.Fatal("a merged set should stay searchable")
+	}
+}
@@ -0,0 +1,68 @@
+package skiplist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// WriteLines writes the items to w in ascending order, one per line as
+// formatted by format, which must not produce newlines. A multiset writes
+// every item, equal ones in insertion order, for ReadMultisetLines.
+func (sl *SkipList) WriteLines(w io.Writer, format func(Item) string) error {
+	bw := bufio.NewWriter(w)
+	for it := sl.NewIterator(); it.Valid(); it.Next() {
+		if _, err := bw.WriteString(format(it.Value()) + "\n"); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadLines creates a skip list from the lines of r, each parsed into an item
+// by parse, such as the output of WriteLines. Lines in strictly ascending
+// order are loaded in one pass by Replace; otherwise the items are inserted
+// one by one, equal ones replacing each other. It returns the first error of
+// reading or parsing, along with the line number for the latter.
+func ReadLines(r io.Reader, parse func(string) (Item, error)) (*SkipList, error) {
+	return readLines(New(), r, parse)
+}
+
+// ReadMultisetLines is like ReadLines but creates a multiset, as
+// NewMultiset does, keeping every line. Equal items keep the order of their
+// lines, so the output of WriteLines on a multiset loads back with the same
+// items in the same order.
+func ReadMultisetLines(r io.Reader, parse func(string) (Item, error)) (*SkipList, error) {
+	return readLines(NewMultiset(), r, parse)
+}
+
+// readLines loads the lines of r into the empty skip list sl.
+func readLines(sl *SkipList, r io.Reader, parse func(string) (Item, error)) (*SkipList, error) {
+	var items []Item
+	sorted := true
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		item, err := parse(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("skiplist: line %d: %w", n, err)
+		}
+		if item == nil {
+			return nil, fmt.Errorf("skiplist: line %d: %w", n, ErrNilItem)
+		}
+		if len(items) > 0 && !sl.placesAfter(items[len(items)-1], item) {
+			sorted = false
+		}
+		items = append(items, item)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if sorted {
+		sl.Replace(items)
+	} else {
+		for _, item := range items {
+			sl.Insert(item)
+		}
+	}
+	return sl, nil
+}
@@ -0,0 +1,101 @@
+package skiplist
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func formatInt(item Item) string {
+	return strconv.Itoa(int(item.(Int)))
+}
+
+func parseInt(s string) (Item, error) {
+	v, err := strconv.Atoi(s)
+	return Int(v), err
+}
+
+func TestLines(t *testing.T) {
+	sl := New()
+	for _, v := range perm(100) {
+		sl.Insert(v)
+	}
+	var buf bytes.Buffer
+	if err := sl.WriteLines(&buf, formatInt); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "0\n1\n2\n") {
+		t.Fatalf("want decimal lines in order, got %q", buf.String()[:10])
+	}
+	loaded, err := ReadLines(&buf, parseInt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.ToInts(), sl.ToInts()) {
+		t.Fatalf("round trip: want %v, got %v", sl.ToInts(), loaded.ToInts())
+	}
+	checkSpans(t, loaded)
+
+	unsorted, err := ReadLines(strings.NewReader("3\n1\n2\n1\n"), parseInt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := unsorted.ToInts(); !reflect.DeepEqual(got, []int{1, 2, 3}) {
+		t.Fatalf("unsorted lines: want [1 2 3], got %v", got)
+	}
+
+	if _, err := ReadLines(strings.NewReader("1\nx\n"), parseInt); !errors.Is(err, strconv.ErrSyntax) || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("want a syntax error on line 2, got %v", err)
+	}
+}
+
+func TestMultisetLines(t *testing.T) {
+	format := func(item Item) string {
+		return strconv.Itoa(item.(kv).key) + " " + strconv.Itoa(item.(kv).value)
+	}
+	parse := func(s string) (Item, error) {
+		var a kv
+		_, err := fmt.Sscan(s, &a.key, &a.value)
+		return a, err
+	}
+	sl := NewMultiset()
+	for i, k := range []int{3, 1, 3, 2, 1, 3} {
+		sl.Insert(kv{k, i})
+	}
+	var buf bytes.Buffer
+	if err := sl.WriteLines(&buf, format); err != nil {
+		t.Fatal(err)
+	}
+	want := "1 1\n1 4\n2 3\n3 0\n3 2\n3 5\n"
+	if buf.String() != want {
+		t.Fatalf("want %q, got %q", want, buf.String())
+	}
+	loaded, err := ReadMultisetLines(&buf, parse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	loaded.WriteLines(&out, format)
+	if out.String() != want {
+		t.Fatalf("round trip: want %q, got %q", want, out.String())
+	}
+	if loaded.CountEqual(kv{key: 3}) != 3 {
+		t.Fatal("the loaded list should stay a multiset")
+	}
+	loaded.Insert(kv{1, 9})
+	if got := loaded.SearchAll(kv{key: 1}); !reflect.DeepEqual(got, []Item{kv{1, 1}, kv{1, 4}, kv{1, 9}}) {
+		t.Fatalf("want later inserts after the loaded ones, got %v", got)
+	}
+
+	shuffled, err := ReadMultisetLines(strings.NewReader("3 0\n1 1\n3 2\n1 4\n"), parse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := shuffled.SearchAll(kv{key: 3}); !reflect.DeepEqual(got, []Item{kv{3, 0}, kv{3, 2}}) {
+		t.Fatalf("unsorted lines: want equal items in line order, got %v", got)
+	}
+}
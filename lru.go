@@ -0,0 +1,96 @@
+package skiplist
+
+// LRU is a cache of at most capacity key-value pairs that evicts the least
+// recently used pair on overflow and iterates its keys in order. It keeps
+// two skip lists: the entries ordered by key and their recency stamps in the
+// order of use, so every operation is O(log n).
+type LRU struct {
+	capacity int
+	entries  *SkipList // of *lruEntry, by key
+	recency  *SkipList // of lruStamp, by stamp
+	clock    uint64
+}
+
+type lruEntry struct {
+	key   Item
+	value interface{}
+	stamp uint64
+}
+
+func (a *lruEntry) Less(b Item) bool {
+	return a.key.Less(b.(*lruEntry).key)
+}
+
+// lruStamp records when an entry was last used.
+type lruStamp struct {
+	stamp uint64
+	entry *lruEntry
+}
+
+func (a lruStamp) Less(b Item) bool {
+	return a.stamp < b.(lruStamp).stamp
+}
+
+// NewLRU creates an LRU holding at most capacity pairs.
+func NewLRU(capacity int) *LRU {
+	if capacity < 1 {
+		panic("capacity must be positive")
+	}
+	return &LRU{capacity: capacity, entries: New(), recency: New()}
+}
+
+// Get returns the value of key and marks it as the most recently used. It
+// returns false if key is absent.
+func (c *LRU) Get(key Item) (interface{}, bool) {
+	item := c.entries.Search(&lruEntry{key: key})
+	if item == nil {
+		return nil, false
+	}
+	e := item.(*lruEntry)
+	c.touch(e)
+	return e.value, true
+}
+
+// Put sets the value of key and marks it as the most recently used, evicting
+// the least recently used pair if the cache overflows.
+func (c *LRU) Put(key Item, value interface{}) {
+	if item := c.entries.Search(&lruEntry{key: key}); item != nil {
+		e := item.(*lruEntry)
+		e.value = value
+		c.touch(e)
+		return
+	}
+	e := &lruEntry{key: key, value: value, stamp: c.tick()}
+	c.entries.Insert(e)
+	c.recency.Insert(lruStamp{stamp: e.stamp, entry: e})
+	if c.entries.Len() > c.capacity {
+		oldest := c.recency.popFront().(lruStamp)
+		c.entries.Delete(oldest.entry)
+	}
+}
+
+// Len returns the number of pairs in the cache.
+func (c *LRU) Len() int {
+	return c.entries.Len()
+}
+
+// ForEach calls f with each pair in ascending order of keys, without marking
+// them as used.
+func (c *LRU) ForEach(f func(key Item, value interface{})) {
+	for it := c.entries.NewIterator(); it.Valid(); it.Next() {
+		e := it.Value().(*lruEntry)
+		f(e.key, e.value)
+	}
+}
+
+// touch marks e as the most recently used.
+func (c *LRU) touch(e *lruEntry) {
+	c.recency.Delete(lruStamp{stamp: e.stamp})
+	e.stamp = c.tick()
+	c.recency.Insert(lruStamp{stamp: e.stamp, entry: e})
+}
+
+func (c *LRU) tick() uint64 {
+	c.clock++
+	return c.clock
+}
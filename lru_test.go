@@ -0,0 +1,36 @@
+package skiplist
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLRU(t *testing.T) {
+	c := NewLRU(3)
+	keys := func() (keys []Item) {
+		c.ForEach(func(key Item, value interface{}) { keys = append(keys, key) })
+		return
+	}
+	c.Put(Int(3), "c")
+	c.Put(Int(1), "a")
+	c.Put(Int(2), "b")
+	if v, ok := c.Get(Int(3)); !ok || v != "c" {
+		t.Fatalf("Get(3): want c, got %v, %v", v, ok)
+	}
+	c.Put(Int(4), "d") // evicts 1, the least recently used
+	if got, want := keys(), []Item{Int(2), Int(3), Int(4)}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("want keys %v, got %v", want, got)
+	}
+	c.Put(Int(2), "B") // updates 2 and uses it
+	c.Put(Int(5), "e") // evicts 3
+	if _, ok := c.Get(Int(3)); ok {
+		t.Fatal("3 should have been evicted")
+	}
+	c.Put(Int(6), "f") // evicts 4
+	if got, want := keys(), []Item{Int(2), Int(5), Int(6)}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("want keys %v, got %v", want, got)
+	}
+	if v, _ := c.Get(Int(2)); v != "B" || c.Len() != 3 {
+		t.Fatalf("want B and 3 pairs, got %v and %d", v, c.Len())
+	}
+}
@@ -0,0 +1,95 @@
+package skiplist
+
+// Map is an ordered map from Item keys to values of any type, built on a
+// skip list of key-value pairs. Keys are ordered by their Less method.
+type Map struct {
+	sl *SkipList
+}
+
+type mapEntry struct {
+	key   Item
+	value interface{}
+}
+
+func (a mapEntry) Less(b Item) bool {
+	return a.key.Less(b.(mapEntry).key)
+}
+
+// NewMap creates an empty Map.
+func NewMap() *Map {
+	return &Map{sl: New()}
+}
+
+// Set sets the value of key, replacing any previous one, in one descent.
+func (m *Map) Set(key Item, value interface{}) {
+	if key == nil {
+		panic(ErrNilItem)
+	}
+	m.sl.Insert(mapEntry{key: key, value: value})
+}
+
+// Get returns the value of key and whether key is present.
+func (m *Map) Get(key Item) (interface{}, bool) {
+	if key == nil {
+		panic(ErrNilItem)
+	}
+	if item := m.sl.Search(mapEntry{key: key}); item != nil {
+		return item.(mapEntry).value, true
+	}
+	return nil, false
+}
+
+// Delete removes key and reports whether it was present.
+func (m *Map) Delete(key Item) bool {
+	if key == nil {
+		panic(ErrNilItem)
+	}
+	return m.sl.Delete(mapEntry{key: key})
+}
+
+// Len returns the number of keys.
+func (m *Map) Len() int {
+	return m.sl.Len()
+}
+
+// ForEach calls f with each pair in ascending order of keys.
+func (m *Map) ForEach(f func(key Item, value interface{})) {
+	for it := m.sl.NewIterator(); it.Valid(); it.Next() {
+		e := it.Value().(mapEntry)
+		f(e.key, e.value)
+	}
+}
+
+// NewIterator returns an iterator at the first pair.
+func (m *Map) NewIterator() *MapIterator {
+	return &MapIterator{it: m.sl.NewIterator()}
+}
+
+// MapIterator walks the pairs of a Map in ascending order of keys.
+type MapIterator struct {
+	it *Iterator
+}
+
+func (it *MapIterator) Valid() bool {
+	return it.it.Valid()
+}
+
+func (it *MapIterator) Next() {
+	it.it.Next()
+}
+
+func (it *MapIterator) Key() Item {
+	return it.it.Value().(mapEntry).key
+}
+
+func (it *MapIterator) Value() interface{} {
+	return it.it.Value().(mapEntry).value
+}
+
+// MoveTo moves the iterator to the first pair whose key is not less than key.
+func (it *MapIterator) MoveTo(key Item) {
+	if key == nil {
+		panic(ErrNilItem)
+	}
+	it.it.MoveTo(mapEntry{key: key})
+}
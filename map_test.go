@@ -0,0 +1,70 @@
+package skiplist
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMap(t *testing.T) {
+	m := NewMap()
+	for _, v := range perm(10) {
+		m.Set(v, int(v.(Int))*10)
+	}
+	m.Set(Int(3), "three")
+	if m.Len() != 10 {
+		t.Fatalf("len: want 10, got %d", m.Len())
+	}
+	if v, ok := m.Get(Int(3)); !ok || v != "three" {
+		t.Fatalf("Get(3): want three, got %v, %v", v, ok)
+	}
+	if v, ok := m.Get(Int(10)); ok || v != nil {
+		t.Fatalf("Get(10): want nil, false, got %v, %v", v, ok)
+	}
+	if !m.Delete(Int(4)) || m.Delete(Int(4)) {
+		t.Fatal("Delete(4) should succeed once")
+	}
+
+	var keys []Item
+	var values []interface{}
+	m.ForEach(func(key Item, value interface{}) {
+		keys = append(keys, key)
+		values = append(values, value)
+	})
+	if want := []Item{Int(0), Int(1), Int(2), Int(3), Int(5), Int(6), Int(7), Int(8), Int(9)}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("keys: want %v, got %v", want, keys)
+	}
+	if values[3] != "three" || values[4] != 50 {
+		t.Fatalf("values out of step with keys: %v", values)
+	}
+
+	it := m.NewIterator()
+	it.MoveTo(Int(4))
+	if !it.Valid() || it.Key() != Int(5) || it.Value() != 50 {
+		t.Fatal("MoveTo(4): want 5 => 50")
+	}
+	for it.Next(); it.Valid(); it.Next() {
+		if it.Value() != int(it.Key().(Int))*10 {
+			t.Fatalf("key %v: got %v", it.Key(), it.Value())
+		}
+	}
+}
+
+func TestMapNilKey(t *testing.T) {
+	m := NewMap()
+	m.Set(Int(1), 1)
+	for name, f := range map[string]func(){
+		"Set":    func() { m.Set(nil, 0) },
+		"Get":    func() { m.Get(nil) },
+		"Delete": func() { m.Delete(nil) },
+		"MoveTo": func() { m.NewIterator().MoveTo(nil) },
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r != ErrNilItem {
+					t.Errorf("%s: want panic with %v, got %v", name, ErrNilItem, r)
+				}
+			}()
+			f()
+		}()
+	}
+}
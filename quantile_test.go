@@ -0,0 +1,35 @@
+package skiplist
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestQuantileSketch(t *testing.T) {
+	s := NewQuantileSketch(1000)
+	if !math.IsNaN(s.Percentile(0.5)) {
+		t.Fatal("empty sketch should return NaN")
+	}
+
+	r := rand.New(rand.NewSource(1))
+	const samples = 1000000
+	for i := 0; i < samples; i++ {
+		s.Add(r.Float64())
+	}
+	if s.Count() != samples {
+		t.Fatalf("count: want %d, got %d", samples, s.Count())
+	}
+	if s.sl.Len() > 1000 {
+		t.Fatalf("kept %d centroids, want at most 1000", s.sl.Len())
+	}
+	for _, q := range []float64{0.5, 0.95, 0.99} {
+		got := s.Percentile(q)
+		if err := math.Abs(got-q) / q; err > 0.01 {
+			t.Errorf("p%v: got %v, relative error %v", q*100, got, err)
+		}
+	}
+	if s.Percentile(0) != s.min || s.Percentile(1) != s.max {
+		t.Fatal("extreme quantiles should be the min and max samples")
+	}
+}
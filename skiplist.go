@@ -1,7 +1,12 @@
 package skiplist
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
 	"math/rand"
+	"strings"
 	"time"
 )
 
@@ -10,24 +15,91 @@ const (
 	DefaultP        = 0.25 // Skiplist P = 1/4
 
 	DefaultFreeListSize = 32
+
+	arenaChunkSize  = 1024                   // nodes carved from each arena block
+	arenaChunkSpans = arenaChunkSize * 4 / 3 // levels of those nodes, 1/(1-P) each
+
+	adaptiveMinLevel = 4 // initial max level of adaptive skip lists
+
+	maintainSteps = 2 // nodes lowered per operation, see SetIncrementalMaintenance
+
+	// tallSlack is how far above ExpectedLevel CheckLevel tolerates the
+	// level; a fair generator gets there with probability about P^tallSlack.
+	tallSlack = 6
 )
 
 var (
 	nilNodes = make([]*node, 16)
 )
 
+var (
+	// ErrNilItem reports a nil item or key. Nil is never a valid item: the
+	// methods of SkipList, ConcurrentSkipList and Map that take items or keys
+	// panic with ErrNilItem, and the Try variants return it.
+	ErrNilItem = errors.New("skiplist: nil item")
+
+	// ErrIncomparable reports an item whose Less panicked when compared with
+	// the items already stored, typically because its type differs.
+	ErrIncomparable = errors.New("skiplist: incomparable item")
+
+	// ErrTooTall reports a skip list whose level is well above what its
+	// length calls for, the sign of a level generator biased upwards.
+	ErrTooTall = errors.New("skiplist: level too high for the length")
+
+	// ErrFull reports an insert into a skip list created by NewFixed whose
+	// slab has no node left: it holds as many items as its capacity, counting
+	// removed nodes still pinned or waiting for Collect.
+	ErrFull = errors.New("skiplist: fixed capacity exceeded")
+
+	// ErrCorrupt reports a skip list whose links, spans or order break the
+	// invariants of the structure.
+	ErrCorrupt = errors.New("skiplist: corrupt structure")
+)
+
 type Item interface {
 	Less(than Item) bool
 }
 
 // node is an element of a skip list
 type node struct {
-	item    Item
-	forward []*node
+	item     Item
+	key      Item // key of item, on lists made by NewWithKey only
+	forward  []*node
+	backward *node     // previous node at level 0, nil for the first one
+	span     []int     // span[i] is the number of level 0 steps to forward[i]
+	gen      uint32    // bumped whenever the node is removed from a list
+	version  uint64    // stamp of the last change, on versioned lists only
+	dead     bool      // soft deleted, see SkipList.SoftDelete
+	wsum     []float64 // wsum[i] is the weight of the items up to forward[i], on weighted lists only
+}
+
+// resize makes n hold lvl levels, reusing its slices when they are large
+// enough.
+func (n *node) resize(lvl int32) {
+	if cap(n.forward) < int(lvl) {
+		n.forward = make([]*node, lvl)
+		n.span = make([]int, lvl)
+	} else {
+		n.forward = n.forward[:lvl]
+		n.span = n.span[:lvl]
+	}
+}
+
+// resizeWeights sizes the weight sums of n to its levels.
+func (n *node) resizeWeights() {
+	if lvl := len(n.forward); cap(n.wsum) < lvl {
+		n.wsum = make([]float64, lvl)
+	} else {
+		n.wsum = n.wsum[:lvl]
+	}
 }
 
 type FreeList struct {
 	freelist []*node
+	arena    *arena // if set, new nodes come from it and every node is kept
+	fixed    bool   // no node is allocated beyond the prewarmed ones
+	noPtrs   bool   // items hold no pointers, so freed nodes keep theirs
+	low      int    // least length since the last shrink
 }
 
 func NewFreeList(size int) *FreeList {
@@ -37,25 +109,69 @@ func NewFreeList(size int) *FreeList {
 func (f *FreeList) newNode(lvl int32) (n *node) {
 	index := len(f.freelist) - 1
 	if index < 0 {
-		n = &node{forward: make([]*node, lvl)}
+		if f.arena != nil {
+			return f.arena.newNode(lvl)
+		}
+		n = &node{forward: make([]*node, lvl), span: make([]int, lvl)}
 		return
 	}
 	n = f.freelist[index]
 	f.freelist[index] = nil
 	f.freelist = f.freelist[:index]
-
-	if cap(n.forward) < int(lvl) {
-		n.forward = make([]*node, lvl)
-	} else {
-		n.forward = n.forward[:lvl]
+	if index < f.low {
+		f.low = index
 	}
+	n.resize(lvl)
 	return
 }
 
+// shrink drops the nodes that stayed at the bottom of the free list since the
+// last shrink, lowering its capacity by as much, and returns their number.
+func (f *FreeList) shrink() int {
+	idle := f.low
+	if f.fixed {
+		idle = 0
+	}
+	if idle > 0 {
+		freelist := make([]*node, len(f.freelist)-idle, cap(f.freelist)-idle)
+		copy(freelist, f.freelist[idle:])
+		f.freelist = freelist
+	}
+	f.low = len(f.freelist)
+	return idle
+}
+
+// prewarm adds n nodes able to hold lvl levels to the free list, growing it
+// as needed. The nodes are allocated in one batch.
+func (f *FreeList) prewarm(n int, lvl int32) {
+	if need := len(f.freelist) + n; need > cap(f.freelist) {
+		freelist := make([]*node, len(f.freelist), need)
+		copy(freelist, f.freelist)
+		f.freelist = freelist
+	}
+	nodes := make([]node, n)
+	forwards := make([]*node, n*int(lvl))
+	spans := make([]int, n*int(lvl))
+	for i := range nodes {
+		nodes[i].forward = forwards[:lvl:lvl]
+		nodes[i].span = spans[:lvl:lvl]
+		forwards, spans = forwards[lvl:], spans[lvl:]
+		f.freelist = append(f.freelist, &nodes[i])
+	}
+}
+
 func (f *FreeList) freeNode(n *node) (out bool) {
-	if len(f.freelist) < cap(f.freelist) {
+	n.gen++
+	// Arena nodes are kept whatever the capacity, as dropping one would not
+	// release its block anyway.
+	if len(f.freelist) < cap(f.freelist) || f.arena != nil {
 		// for gc
-		n.item = nil
+		if !f.noPtrs {
+			n.item = nil
+		}
+		n.key = nil
+		n.backward = nil
+		n.dead = false
 		toClear := n.forward
 		for len(toClear) > 0 {
 			toClear = toClear[copy(toClear, nilNodes):]
@@ -67,6 +183,29 @@ func (f *FreeList) freeNode(n *node) (out bool) {
 	return
 }
 
+// arena hands out nodes carved from contiguous blocks, so a list of n nodes
+// takes about n/arenaChunkSize allocations and the nodes sit close together.
+type arena struct {
+	nodes    []node
+	forwards []*node
+	spans    []int
+}
+
+func (a *arena) newNode(lvl int32) *node {
+	if len(a.nodes) == 0 {
+		a.nodes = make([]node, arenaChunkSize)
+	}
+	if len(a.forwards) < int(lvl) {
+		a.forwards = make([]*node, arenaChunkSpans+lvl)
+		a.spans = make([]int, arenaChunkSpans+lvl)
+	}
+	n := &a.nodes[0]
+	a.nodes = a.nodes[1:]
+	n.forward, a.forwards = a.forwards[:lvl:lvl], a.forwards[lvl:]
+	n.span, a.spans = a.spans[:lvl:lvl], a.spans[lvl:]
+	return n
+}
+
 // SkipList implemente "Skip Lists: A Probabilistic Alternative to Balanced Trees"
 type SkipList struct {
 	header   *node
@@ -75,6 +214,47 @@ type SkipList struct {
 	freelist *FreeList
 	length   int
 	random   *rand.Rand
+
+	levelFunc     func() int32
+	maxNodeLevel  int32   // if set, caps the level of new nodes
+	countSearches bool    // Search updates stats, see SetSearchCounting
+	p             float32 // if set, replaces DefaultP, see SetP
+	stats         Stats
+	adaptive      bool
+	versioned     bool
+	version       uint64 // stamp of the next change on versioned lists
+
+	tombstones int  // soft deleted nodes still linked
+	multi      bool // equal items are kept side by side
+
+	lessFunc   func(a, b Item) bool // if set, orders the items instead of Item.Less
+	descending bool                 // lessFunc reverses Item.Less
+	keyOf      func(Item) Item      // if set, the nodes cache the keys, see NewWithKey
+
+	onOverwrite func(item Item)
+	copier      func(Item) Item // if set, copies the items handed out, see SetItemCopier
+	bloom       *bloom          // if set, filters the keys Search looks up
+	pins        map[*node]*pin
+	deferFree   bool // removed nodes wait in pending until Collect
+	maintain    bool // inserts and deletes lower tall nodes, see SetIncrementalMaintenance
+	pending     []*node
+
+	weight      func(Item) float64 // if set, nodes keep weight sums, see NewWeighted
+	totalWeight float64
+}
+
+// Stats holds lifetime counters of the operations on a skip list. An Insert
+// counts either as an insert, when it adds a node, or as an overwrite, when it
+// replaces an equal item; it never counts as both. Deletes counts every item
+// unlinked, whether by Delete, the Pop and trim methods or InsertWindow; a
+// soft deleted item counts once Compact or another of them unlinks it.
+type Stats struct {
+	Inserts      int // inserts that added a new item
+	Overwrites   int // inserts that replaced an equal item
+	Deletes      int // items unlinked from the list
+	DeleteMisses int // deletes that found no equal item
+	Searches     int // calls of Search, if counted, see SetSearchCounting
+	Comparisons  int // comparisons made by Search, if counted
 }
 
 // New creates a skip list
@@ -84,6 +264,50 @@ func New() *SkipList {
 
 // NewWithLevel creates a skip list with the given max level
 func NewWithLevel(maxLevel int32) *SkipList {
+	return newSkipList(maxLevel, rand.New(rand.NewSource(time.Now().UnixNano())))
+}
+
+// NewWithArena creates a skip list allocating its nodes in contiguous blocks
+// rather than one by one. This cuts allocations and the GC work of scanning
+// many small objects for large lists, at the cost of memory: a block is only
+// released once all of its nodes are unreachable, so deleted nodes are kept
+// for reuse rather than dropped.
+func NewWithArena() *SkipList {
+	sl := New()
+	sl.freelist.arena = &arena{}
+	return sl
+}
+
+// NewFixed creates a skip list whose nodes all come from a slab allocated up
+// front, holding at most capacity items of up to maxLevel levels each. The
+// slab takes about capacity*maxLevel*16 bytes besides the nodes themselves,
+// and no insert allocates after that. Deleted nodes go back to the slab, but
+// only once they are free: a deleted node still pinned, or waiting for
+// Collect under SetDeferredFree, keeps its place, so the list can be full
+// with fewer than capacity items. Inserting a new item into a full list
+// panics with ErrFull, TryInsertItem returns it, and the list is left
+// unchanged.
+func NewFixed(capacity int, maxLevel int32) *SkipList {
+	sl := NewWithLevel(maxLevel)
+	sl.freelist = &FreeList{fixed: true}
+	sl.freelist.prewarm(capacity, maxLevel)
+	return sl
+}
+
+// full reports whether a fixed skip list has no node left for a new item.
+func (sl *SkipList) full() bool {
+	return sl.freelist.fixed && len(sl.freelist.freelist) == 0
+}
+
+// NewWithRand creates a skip list drawing node levels from r. Sharing one r
+// between many lists saves allocating and seeding a generator for each, but
+// r is not safe for concurrent use: lists sharing it must not be modified
+// concurrently.
+func NewWithRand(r *rand.Rand) *SkipList {
+	return newSkipList(DefaultMaxLevel, r)
+}
+
+func newSkipList(maxLevel int32, r *rand.Rand) *SkipList {
 	if maxLevel < 1 || maxLevel > DefaultMaxLevel {
 		panic("maxLevel must be between 1 and DefaultMaxLevel")
 	}
@@ -93,176 +317,2880 @@ func NewWithLevel(maxLevel int32) *SkipList {
 		freelist: NewFreeList(DefaultFreeListSize),
 		header: &node{
 			forward: make([]*node, maxLevel),
+			span:    make([]int, maxLevel),
 		},
-		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+		random: r,
+	}
+}
+
+// NewAdaptive creates a skip list whose max level grows with its length. It
+// starts at a small max level and raises it by one whenever Len() exceeds
+// (1/P)^maxLevel, up to DefaultMaxLevel, keeping searches optimal for lists
+// of any size without picking a max level up front. The max level never
+// shrinks.
+func NewAdaptive() *SkipList {
+	sl := NewWithLevel(adaptiveMinLevel)
+	sl.header.forward = make([]*node, adaptiveMinLevel, DefaultMaxLevel)
+	sl.header.span = make([]int, adaptiveMinLevel, DefaultMaxLevel)
+	sl.adaptive = true
+	return sl
+}
+
+// NewMultiset creates a skip list that keeps equal items instead of replacing
+// them. Equal items are kept in insertion order, first in first out; Search
+// and Delete act on the first of them. The order comes from inserting every
+// item after those equal to it, so it costs no sequence number per node and
+// no extra comparison, and Less need not break ties.
+func NewMultiset() *SkipList {
+	sl := New()
+	sl.multi = true
+	return sl
+}
+
+// NewDescending creates a skip list keeping its items in descending order of
+// their Less method, greatest first, as leaderboards rank them: GetByRank(1)
+// is the greatest item, and iterators and ranges walk down from it. Ascending
+// gives the items in ascending order, walking the list backwards.
+func NewDescending() *SkipList {
+	sl := New()
+	sl.lessFunc = func(a, b Item) bool { return b.Less(a) }
+	sl.descending = true
+	return sl
+}
+
+// NewWithComparator creates a skip list ordering its items by less instead of
+// their Less method, so that several lists can order the same items
+// differently. Items of types without a Less method can be stored wrapped
+// in Any, less unwrapping them.
+func NewWithComparator(less func(a, b Item) bool) *SkipList {
+	if less == nil {
+		panic("less must not be nil")
+	}
+	sl := New()
+	sl.lessFunc = less
+	return sl
+}
+
+// NewWithKey creates a skip list ordering its items by the keys key returns
+// for them, compared by their Less method. It is meant for items whose Less
+// is expensive, such as one deriving a key on every call: each node keeps
+// the key of its item, computed once when it is stored, and Search, Insert,
+// Delete, Iterator.MoveTo and the ranges extract the key they look for once,
+// then descend comparing keys only. The other queries extract keys on every
+// comparison. key must give equal keys for an item for as long as it is
+// stored.
+func NewWithKey(key func(Item) Item) *SkipList {
+	if key == nil {
+		panic("key must not be nil")
+	}
+	sl := New()
+	sl.keyOf = key
+	sl.lessFunc = func(a, b Item) bool { return key(a).Less(key(b)) }
+	return sl
+}
+
+// probe returns what the descents compare the nodes with to find item: its
+// key on a list made by NewWithKey, else item itself.
+func (sl *SkipList) probe(item Item) Item {
+	if sl.keyOf != nil {
+		return sl.keyOf(item)
+	}
+	return item
+}
+
+// nodeLess reports whether the node x sorts before the probe k.
+func (sl *SkipList) nodeLess(x *node, k Item) bool {
+	if sl.keyOf != nil {
+		return x.key.Less(k)
+	}
+	return sl.less(x.item, k)
+}
+
+// probeLess reports whether the probe k sorts before the node x.
+func (sl *SkipList) probeLess(k Item, x *node) bool {
+	if sl.keyOf != nil {
+		return k.Less(x.key)
+	}
+	return sl.less(k, x.item)
+}
+
+// setItem stores item in x, along with its probe k on a list made by
+// NewWithKey.
+func (sl *SkipList) setItem(x *node, item, k Item) {
+	x.item = item
+	if sl.keyOf != nil {
+		x.key = k
+	}
+}
+
+// less reports whether a sorts before b in the skip list.
+func (sl *SkipList) less(a, b Item) bool {
+	if sl.lessFunc != nil {
+		return sl.lessFunc(a, b)
+	}
+	return a.Less(b)
+}
+
+// Resort returns a new skip list holding the same items ordered by less
+// instead of their Less method, such as a descending order. Items equal under
+// less collapse into the last one unless the skip list is a multiset. The
+// skip list itself is left unchanged.
+func (sl *SkipList) Resort(less func(a, b Item) bool) *SkipList {
+	resorted := New()
+	resorted.multi = sl.multi
+	resorted.lessFunc = less
+	for it := sl.NewIterator(); it.Valid(); it.Next() {
+		resorted.Insert(it.Value())
+	}
+	return resorted
+}
+
+// placesAfter reports whether item goes after the stored item y: when y is
+// less than item or, in a multiset, equal to it.
+func (sl *SkipList) placesAfter(y, item Item) bool {
+	if sl.multi {
+		return !sl.less(item, y)
+	}
+	return sl.less(y, item)
+}
+
+// nodePlacesAfter is placesAfter for the node y and the probe k of an item.
+func (sl *SkipList) nodePlacesAfter(y *node, k Item) bool {
+	if sl.multi {
+		return !sl.probeLess(k, y)
 	}
+	return sl.nodeLess(y, k)
+}
+
+// NewWeighted creates a skip list keeping the running totals of weight over
+// its items, so FindByWeight can pick an item by cumulative weight in
+// O(log n). weight must be non-negative and give the same result for an item
+// for as long as it is stored.
+func NewWeighted(weight func(Item) float64) *SkipList {
+	sl := New()
+	sl.weight = weight
+	sl.header.wsum = make([]float64, sl.maxLevel)
+	return sl
+}
+
+// NewVersioned creates a skip list that stamps every inserted or overwritten
+// item with an increasing version, so NewIteratorSince can visit just the
+// items changed after a given point. Other lists don't maintain the stamps.
+func NewVersioned() *SkipList {
+	sl := New()
+	sl.versioned = true
+	sl.version = 1
+	return sl
+}
+
+// Version returns the version the next inserted or overwritten item will be
+// stamped with. Items stamped before all have a lower version.
+func (sl *SkipList) Version() uint64 {
+	return sl.version
+}
+
+// stamp records a change of the item of x on versioned skip lists.
+func (sl *SkipList) stamp(x *node) {
+	if sl.versioned {
+		x.version = sl.version
+		sl.version++
+	}
+}
+
+// fit raises the max level of an adaptive skip list until it suits n items.
+func (sl *SkipList) fit(n int) {
+	for sl.maxLevel < DefaultMaxLevel && float64(n) > math.Pow(1/float64(sl.prob()), float64(sl.maxLevel)) {
+		sl.maxLevel++
+		sl.header.forward = sl.header.forward[:sl.maxLevel]
+		sl.header.span = sl.header.span[:sl.maxLevel]
+		sl.header.span[sl.maxLevel-1] = sl.length
+		if sl.weight != nil {
+			sl.header.wsum = sl.header.wsum[:sl.maxLevel]
+			sl.header.wsum[sl.maxLevel-1] = sl.totalWeight
+		}
+	}
+}
+
+// newNode takes a node of lvl levels from the free list.
+func (sl *SkipList) newNode(lvl int32) *node {
+	x := sl.freelist.newNode(lvl)
+	if sl.weight != nil {
+		x.resizeWeights()
+	}
+	return x
 }
 
 // Search for an element by traversing forward pointers
 func (sl *SkipList) Search(key Item) Item {
+	if key == nil {
+		panic(ErrNilItem)
+	}
+	if sl.bloom != nil && !sl.bloom.mayContain(key) {
+		if sl.countSearches {
+			sl.stats.Searches++
+		}
+		return nil
+	}
 	x := sl.header
+	k := sl.probe(key)
+	cmps := 0
+	// stop is the node that ended the walk on the level above: it is known
+	// not to be less than key, so it is not compared again.
+	var stop *node
 	// loop : x→key < searchKey <= x→forward[i]→key
 	for i := sl.level - 1; i >= 0; i-- {
-		for y := x.forward[i]; y != nil && y.item.Less(key); y = x.forward[i] {
+		for y := x.forward[i]; y != stop && sl.countedLess(&cmps, y, k); y = x.forward[i] {
 			x = y
 		}
+		stop = x.forward[i]
 	}
 
-	if x = x.forward[0]; x != nil && !key.Less(x.item) {
-		return x.item
+	x = sl.skipDead(x.forward[0], key)
+	found := false
+	if x != nil {
+		cmps++
+		found = !sl.probeLess(k, x) && !x.dead
+	}
+	if sl.countSearches {
+		sl.stats.Searches++
+		sl.stats.Comparisons += cmps
+	}
+	if found {
+		return sl.out(x.item)
 	}
 	return nil
 }
 
-func (sl *SkipList) searchNode(key Item) *node {
+// skipDead returns x, or while x is soft deleted and equal to key, the first
+// node after it that is not, so that a multiset whose first item equal to key
+// was soft deleted still finds the others.
+func (sl *SkipList) skipDead(x *node, key Item) *node {
+	for x != nil && x.dead && !sl.less(key, x.item) {
+		x = x.forward[0]
+	}
+	return x
+}
+
+// countedLess is nodeLess counting the call in *n.
+func (sl *SkipList) countedLess(n *int, x *node, k Item) bool {
+	*n++
+	return sl.nodeLess(x, k)
+}
+
+// SetSearchCounting turns on or off the counting of the calls of Search and
+// of their comparisons in Stats, for AvgComparisonsPerSearch. It is off by
+// default because counting makes Search write to the skip list: while it is
+// on, concurrent Searches, such as under a read lock, race.
+func (sl *SkipList) SetSearchCounting(on bool) {
+	sl.countSearches = on
+}
+
+// AvgComparisonsPerSearch returns the mean number of comparisons Search has
+// made per call while counted, see SetSearchCounting, a little under
+// (1/P) log_{1/P} n for a healthy list of n items. A tuner can watch it while
+// adjusting SetP. Searches the Bloom filter of NewWithBloom rejects count
+// with no comparison.
+func (sl *SkipList) AvgComparisonsPerSearch() float64 {
+	if sl.stats.Searches == 0 {
+		return 0
+	}
+	return float64(sl.stats.Comparisons) / float64(sl.stats.Searches)
+}
+
+// SearchAll returns every item equal to key, in insertion order, or nil if
+// there is none. Only a multiset holds more than one.
+func (sl *SkipList) SearchAll(key Item) []Item {
+	if key == nil {
+		panic(ErrNilItem)
+	}
+	var items []Item
+	for x := sl.searchNode(key); x != nil && !sl.less(key, x.item); x = x.forward[0] {
+		if !x.dead {
+			items = append(items, sl.out(x.item))
+		}
+	}
+	return items
+}
+
+// CountEqual returns the number of items equal to key: 0 or 1, except in a
+// multiset.
+func (sl *SkipList) CountEqual(key Item) int {
+	if key == nil {
+		panic(ErrNilItem)
+	}
+	n := 0
+	for x := sl.searchNode(key); x != nil && !sl.less(key, x.item); x = x.forward[0] {
+		if !x.dead {
+			n++
+		}
+	}
+	return n
+}
+
+// DistinctLen returns the number of distinct items, which is Len except in a
+// multiset. There it hops from each key to the next with a search, so it
+// takes O(d log n) time for d distinct keys rather than a walk of the list,
+// cheap when many items share few keys.
+func (sl *SkipList) DistinctLen() int {
+	if !sl.multi {
+		return sl.Len()
+	}
+	n := 0
+	for x := sl.header.forward[0]; x != nil; {
+		next := sl.afterNode(x.item)
+		for x != next && x.dead {
+			x = x.forward[0]
+		}
+		if x != next {
+			n++
+		}
+		x = next
+	}
+	return n
+}
+
+// afterNode returns the first node greater than key, or nil if there is none.
+func (sl *SkipList) afterNode(key Item) *node {
 	x := sl.header
-	// loop : x→key < searchKey <= x→forward[i]→key
+	k := sl.probe(key)
+	var stop *node // greater than key, see Search
 	for i := sl.level - 1; i >= 0; i-- {
-		for y := x.forward[i]; y != nil && y.item.Less(key); y = x.forward[i] {
+		for y := x.forward[i]; y != stop && !sl.probeLess(k, y); y = x.forward[i] {
 			x = y
 		}
+		stop = x.forward[i]
 	}
 	return x.forward[0]
 }
 
-// Insert adds the given item to the skip list.
-func (sl *SkipList) Insert(item Item) {
-	if item == nil {
-		panic("nil item being added to SkipList")
+// SearchBudget is like Search but gives up once more than maxSteps nodes have
+// been visited. It returns the item found, whether it was found, and whether
+// the budget ran out before the search could tell.
+func (sl *SkipList) SearchBudget(key Item, maxSteps int) (item Item, found, exhausted bool) {
+	if key == nil {
+		panic(ErrNilItem)
 	}
-	var staticAlloc [DefaultMaxLevel]*node
-	var prev = staticAlloc[:sl.maxLevel]
+	var steps int
 	x := sl.header
 	for i := sl.level - 1; i >= 0; i-- {
-		for y := x.forward[i]; y != nil && y.item.Less(item); y = x.forward[i] {
+		for y := x.forward[i]; y != nil; y = x.forward[i] {
+			if steps++; steps > maxSteps {
+				return nil, false, true
+			}
+			if !sl.less(y.item, key) {
+				break
+			}
 			x = y
 		}
-		prev[i] = x
 	}
-	x = x.forward[0]
-	if x != nil && !item.Less(x.item) {
-		x.item = item
-	} else {
-		lvl := sl.randomLevel()
-		if lvl > sl.level {
-			for i := sl.level; i < lvl; i++ {
-				prev[i] = sl.header
-			}
-			sl.level = lvl
-		}
 
-		x = sl.freelist.newNode(lvl)
-		x.item = item
-		for i := int32(0); i < lvl; i++ {
-			x.forward[i], prev[i].forward[i] = prev[i].forward[i], x
-		}
-		sl.length++
+	if x = sl.skipDead(x.forward[0], key); x != nil && !sl.less(key, x.item) && !x.dead {
+		return sl.out(x.item), true, false
 	}
+	return nil, false, false
 }
 
-// Delete remote an item equal to the passed in item. return true if success, else false.
-func (sl *SkipList) Delete(item Item) bool {
-	var staticAlloc [DefaultMaxLevel]*node
-	var prev = staticAlloc[:sl.maxLevel]
+// Context returns the item equal to key along with its predecessor and
+// successor, all found in one descent. If no item equals key, cur is nil and
+// prev and next are the items around where key would be. prev or next are
+// nil at the ends of the list.
+func (sl *SkipList) Context(key Item) (prev, cur, next Item, found bool) {
+	if key == nil {
+		panic(ErrNilItem)
+	}
 	x := sl.header
 	for i := sl.level - 1; i >= 0; i-- {
-		for y := x.forward[i]; y != nil && y.item.Less(item); y = x.forward[i] {
+		for y := x.forward[i]; y != nil && sl.less(y.item, key); y = x.forward[i] {
 			x = y
 		}
-		prev[i] = x
 	}
-	x = x.forward[0]
-	if x != nil && !item.Less(x.item) {
-		for i := int32(0); i < sl.level; i++ {
-			if prev[i].forward[i] != x {
-				break
-			}
-			prev[i].forward[i] = x.forward[i]
+	for p := x; p != nil && p != sl.header; p = p.backward {
+		if !p.dead {
+			prev = sl.out(p.item)
+			break
 		}
-		for sl.level > 1 && sl.header.forward[sl.level-1] == nil {
-			sl.level--
+	}
+	if x = sl.skipDead(x.forward[0], key); x != nil && !sl.less(key, x.item) {
+		cur, found = sl.out(x.item), true
+		x = x.forward[0]
+	}
+	for ; x != nil; x = x.forward[0] {
+		if !x.dead {
+			next = sl.out(x.item)
+			break
 		}
-		sl.freelist.freeNode(x)
-		sl.length--
-		return true
 	}
-	return false
+	return
 }
 
-func (sl *SkipList) randomLevel() int32 {
-	lvl := int32(1)
-	for lvl < sl.maxLevel && float32(sl.random.Uint32()&0xFFFF) < DefaultP*0xFFFF {
-		lvl++
-	}
-	return lvl
+// ClosestMode selects the item SearchClosest falls back to when no item
+// equals the key.
+type ClosestMode struct {
+	ceiling bool
+	dist    func(a, b Item) float64
 }
 
-func (sl *SkipList) Len() int {
-	return sl.length
-}
+var (
+	// Floor selects the greatest item less than the key.
+	Floor = ClosestMode{}
+	// Ceiling selects the least item greater than the key.
+	Ceiling = ClosestMode{ceiling: true}
+)
 
-func (sl *SkipList) NewIterator() *Iterator {
-	return &Iterator{sl: sl, x: sl.header.forward[0]}
+// Nearest selects whichever of the floor and the ceiling is nearer to the key
+// by dist, the floor on a tie.
+func Nearest(dist func(a, b Item) float64) ClosestMode {
+	return ClosestMode{dist: dist}
 }
 
-func (sl *SkipList) NewRange(begin, end Item) *Range {
-	minNode := sl.header.forward[0]
-	if minNode == nil || end.Less(begin) {
-		return &Range{}
+// SearchClosest returns the item equal to key or, if there is none, the one
+// selected by mode. It returns nil if there is no such item, such as the
+// floor of a key below every item.
+func (sl *SkipList) SearchClosest(key Item, mode ClosestMode) Item {
+	if key == nil {
+		panic(ErrNilItem)
 	}
-
-	beginNode := sl.searchNode(begin)
-	if beginNode == nil && begin.Less(minNode.item) {
-		beginNode = minNode
+	prev, cur, next, found := sl.Context(key)
+	switch {
+	case found:
+		return cur
+	case mode.dist != nil:
+		if prev == nil || next != nil && mode.dist(next, key) < mode.dist(prev, key) {
+			return next
+		}
+		return prev
+	case mode.ceiling:
+		return next
+	default:
+		return prev
 	}
+}
 
-	nend := sl.searchNode(end)
-	if nend == nil {
-		if end.Less(minNode.item) {
-			nend = minNode
-		}
-	} else {
-		if !end.Less(nend.item) {
-			nend = nend.forward[0]
+func (sl *SkipList) searchNode(key Item) *node {
+	x := sl.header
+	k := sl.probe(key)
+	var stop *node // not less than key, see Search
+	// loop : x→key < searchKey <= x→forward[i]→key
+	for i := sl.level - 1; i >= 0; i-- {
+		for y := x.forward[i]; y != stop && sl.nodeLess(y, k); y = x.forward[i] {
+			x = y
 		}
+		stop = x.forward[i]
 	}
-	return &Range{
-		sl:    sl,
-		begin: beginNode,
-		end:   nend,
-	}
+	return x.forward[0]
 }
 
-type Iterator struct {
-	sl *SkipList
-	x  *node
+// ApproxRank returns an estimate of the number of items less than or equal
+// to key. Every hop made at level i during the search descent is counted as
+// (1/P)^i items, the expected gap between nodes of that level, so the result
+// costs no more than a Search. The estimate is unbiased but coarse: its error
+// is of the order of the gaps between nodes on the highest levels, which may
+// be a sizeable fraction of Len(). Use it for rough percentiles only.
+func (sl *SkipList) ApproxRank(key Item) int {
+	return sl.clampCount(sl.approxCount(key, true))
 }
 
-func (it *Iterator) Valid() bool {
-	return it.x != nil
+// ApproxCountRange returns an estimate of the number of items in [begin,
+// end], the difference of the estimates ApproxRank makes for both bounds, at
+// the cost of two searches. The hops both descents share cancel out, but the
+// error remains of the order of the gap between nodes on the levels where
+// they part, whatever the size of the range: it is only a fair estimate for
+// ranges holding a sizeable fraction of the items. On 10000 items, ranges
+// of half of them are off by about 40% on average, and ranges of 10 items by
+// 100% or more.
+func (sl *SkipList) ApproxCountRange(begin, end Item) int {
+	if begin == nil || end == nil {
+		panic(ErrNilItem)
+	}
+	if sl.less(end, begin) {
+		return 0
+	}
+	return sl.clampCount(sl.approxCount(end, true) - sl.approxCount(begin, false))
 }
 
-func (it *Iterator) Next() {
-	it.x = it.x.forward[0]
+// approxCount estimates the number of items less than key, or less than or
+// equal to it if inclusive, for ApproxRank.
+func (sl *SkipList) approxCount(key Item, inclusive bool) float64 {
+	if key == nil {
+		panic(ErrNilItem)
+	}
+	var rank float64
+	p := float64(sl.prob())
+	gap := math.Pow(1/p, float64(sl.level-1))
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for y := x.forward[i]; y != nil && sl.less(y.item, key); y = x.forward[i] {
+			x = y
+			rank += gap
+		}
+		gap *= p
+	}
+	if x = x.forward[0]; inclusive && x != nil && !sl.less(key, x.item) {
+		rank++
+	}
+	return rank
 }
 
-func (it *Iterator) Value() Item {
-	return it.x.item
+// clampCount rounds an estimated count into [0, length].
+func (sl *SkipList) clampCount(n float64) int {
+	switch {
+	case n < 0:
+		return 0
+	case int(n+0.5) > sl.length:
+		return sl.length
+	}
+	return int(n + 0.5)
 }
 
-func (it *Iterator) MoveTo(item Item) {
-	it.x = it.sl.searchNode(item)
+// CountLess returns the number of items less than key.
+func (sl *SkipList) CountLess(key Item) int {
+	if key == nil {
+		panic(ErrNilItem)
+	}
+	var rank int
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for y := x.forward[i]; y != nil && sl.less(y.item, key); y = x.forward[i] {
+			rank += x.span[i]
+			x = y
+		}
+	}
+	return rank
 }
 
-type Range struct {
-	sl         *SkipList
-	begin, end *node
+// RankRangeOf returns the 1-based ranks of the first and last items equal to
+// key, which differ only in a multiset, in O(log n) using the spans, or
+// O(n) while soft deleted items wait for Compact. It returns 0, 0, false if
+// no item equals key.
+func (sl *SkipList) RankRangeOf(key Item) (first, last int, found bool) {
+	if key == nil {
+		panic(ErrNilItem)
+	}
+	if sl.tombstones > 0 {
+		// positions skip soft deleted items, which the spans count
+		x := sl.header.forward[0]
+		for ; x != nil && sl.less(x.item, key); x = x.forward[0] {
+			if !x.dead {
+				first++
+			}
+		}
+		last = first
+		for ; x != nil && !sl.less(key, x.item); x = x.forward[0] {
+			if !x.dead {
+				last++
+			}
+		}
+		if last == first {
+			return 0, 0, false
+		}
+		return first + 1, last, true
+	}
+	first, last = sl.CountLess(key)+1, sl.length-sl.CountGreater(key)
+	if last < first {
+		return 0, 0, false
+	}
+	return first, last, true
 }
 
-func (r *Range) ForEach(f func(item Item)) {
-	for x := r.begin; x != r.end; x = x.forward[0] {
-		f(x.item)
-	}
+// SearchIndex returns the index at which key would be inserted to keep the
+// items sorted, whether or not it is present, like sort.Search over the
+// items in order. It is the number of items less than key.
+func (sl *SkipList) SearchIndex(key Item) int {
+	return sl.CountLess(key)
+}
+
+// CountGreater returns the number of items greater than key.
+func (sl *SkipList) CountGreater(key Item) int {
+	if key == nil {
+		panic(ErrNilItem)
+	}
+	var rank int
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for y := x.forward[i]; y != nil && !sl.less(key, y.item); y = x.forward[i] {
+			rank += x.span[i]
+			x = y
+		}
+	}
+	return sl.length - rank
+}
+
+// NthGreater returns the k-th least item strictly greater than key, so k=1
+// gives the successor of key. It returns false if fewer than k items are
+// greater than key or if k is not positive.
+func (sl *SkipList) NthGreater(key Item, k int) (Item, bool) {
+	if key == nil {
+		panic(ErrNilItem)
+	}
+	if k < 1 {
+		return nil, false
+	}
+	if sl.tombstones == 0 {
+		if x := sl.nodeByRank(sl.length - sl.CountGreater(key) + k); x != nil {
+			return sl.out(x.item), true
+		}
+		return nil, false
+	}
+	for x := sl.afterNode(key); x != nil; x = x.forward[0] {
+		if !x.dead {
+			if k--; k == 0 {
+				return sl.out(x.item), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Median returns the lower median, the item at position (Len()+1)/2, or nil
+// if the skip list is empty.
+func (sl *SkipList) Median() Item {
+	if x := sl.liveByRank((sl.Len() + 1) / 2); x != nil {
+		return sl.out(x.item)
+	}
+	return nil
+}
+
+// AtFraction returns the item at 0-based position round(f*(Len()-1)), f
+// being clamped to [0, 1], or nil if the skip list is empty. It samples the
+// sorted items at a fraction of their range: 0 gives the least item and 1 the
+// greatest.
+func (sl *SkipList) AtFraction(f float64) Item {
+	f = math.Max(0, math.Min(1, f))
+	if x := sl.liveByRank(int(math.Round(f*float64(sl.Len()-1))) + 1); x != nil {
+		return sl.out(x.item)
+	}
+	return nil
+}
+
+// RangeByRank returns the items from 1-based position from to position to,
+// both inclusive. Positions outside [1, Len()] are clamped.
+func (sl *SkipList) RangeByRank(from, to int) []Item {
+	if from < 1 {
+		from = 1
+	}
+	if to > sl.Len() {
+		to = sl.Len()
+	}
+	if from > to {
+		return nil
+	}
+	items := make([]Item, 0, to-from+1)
+	for x := sl.liveByRank(from); len(items) < cap(items); x = x.forward[0] {
+		if !x.dead {
+			items = append(items, sl.out(x.item))
+		}
+	}
+	return items
+}
+
+// Partition splits the items into n contiguous runs, in order, whose lengths
+// differ by at most one, so they can be handed to parallel workers. If n is
+// greater than Len(), it returns Len() runs of one item.
+func (sl *SkipList) Partition(n int) [][]Item {
+	if n < 1 {
+		panic("n must be positive")
+	}
+	length := sl.Len()
+	if n > length {
+		n = length
+	}
+	parts := make([][]Item, 0, n)
+	x := sl.header.forward[0]
+	for k := 0; k < n; k++ {
+		part := make([]Item, 0, (k+1)*length/n-k*length/n)
+		for ; len(part) < cap(part); x = x.forward[0] {
+			if !x.dead {
+				part = append(part, sl.out(x.item))
+			}
+		}
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+// Rank returns the 1-based position of the item equal to item, the first of
+// them in a multiset, or 0 if there is none, in O(log n) using the spans,
+// or O(n) while soft deleted items wait for Compact. GetByRank(Rank(item))
+// returns the item.
+func (sl *SkipList) Rank(item Item) int {
+	if item == nil {
+		panic(ErrNilItem)
+	}
+	var rank int
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for y := x.forward[i]; y != nil && sl.less(y.item, item); y = x.forward[i] {
+			rank += x.span[i]
+			x = y
+		}
+	}
+	y := x.forward[0]
+	for ; y != nil && y.dead && !sl.less(item, y.item); y = y.forward[0] {
+		rank++
+	}
+	if y == nil || sl.less(item, y.item) {
+		return 0
+	}
+	if sl.tombstones > 0 {
+		// positions skip soft deleted items, which the spans count
+		for z := sl.header.forward[0]; z != y; z = z.forward[0] {
+			if z.dead {
+				rank--
+			}
+		}
+	}
+	return rank + 1
+}
+
+// GetByRank returns the item at the given 1-based position, or nil if there
+// is no such position, in O(log n).
+func (sl *SkipList) GetByRank(rank int) Item {
+	if x := sl.liveByRank(rank); x != nil {
+		return sl.out(x.item)
+	}
+	return nil
+}
+
+// nodeByRank returns the node at the given 1-based position, or nil if there
+// is no such position.
+func (sl *SkipList) nodeByRank(rank int) *node {
+	if rank < 1 || rank > sl.length {
+		return nil
+	}
+	var traversed int
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.forward[i] != nil && traversed+x.span[i] <= rank {
+			traversed += x.span[i]
+			x = x.forward[i]
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
+
+// liveByRank returns the node at the given 1-based position among the items
+// not soft deleted, or nil if there is no such position. The spans count
+// soft deleted nodes too, so while any wait for Compact it walks level 0.
+func (sl *SkipList) liveByRank(rank int) *node {
+	if sl.tombstones == 0 {
+		return sl.nodeByRank(rank)
+	}
+	if rank < 1 || rank > sl.Len() {
+		return nil
+	}
+	for x := sl.header.forward[0]; x != nil; x = x.forward[0] {
+		if !x.dead {
+			if rank--; rank == 0 {
+				return x
+			}
+		}
+	}
+	return nil
+}
+
+// FindByWeight returns the first item whose cumulative weight, that of the
+// item and all those before it, reaches target, or nil if the total weight
+// is less than target. With target drawn uniformly from [0, TotalWeight()),
+// it selects items with probability proportional to their weight. It panics
+// unless the skip list was created by NewWeighted.
+func (sl *SkipList) FindByWeight(target float64) Item {
+	if sl.weight == nil {
+		panic("skiplist: FindByWeight on a list without weights")
+	}
+	var acc float64
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.forward[i] != nil && acc+x.wsum[i] < target {
+			acc += x.wsum[i]
+			x = x.forward[i]
+		}
+	}
+	// soft deleted nodes weigh nothing, so skip those reaching target first
+	x = x.forward[0]
+	for x != nil && x.dead {
+		x = x.forward[0]
+	}
+	if x == nil {
+		return nil
+	}
+	return sl.out(x.item)
+}
+
+// PrefixSum returns the total weight of the items less than or equal to key,
+// in O(log n). It panics unless the skip list was created by NewWeighted.
+func (sl *SkipList) PrefixSum(key Item) float64 {
+	if key == nil {
+		panic(ErrNilItem)
+	}
+	if sl.weight == nil {
+		panic("skiplist: PrefixSum on a list without weights")
+	}
+	var sum float64
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for y := x.forward[i]; y != nil && !sl.less(key, y.item); y = x.forward[i] {
+			sum += x.wsum[i]
+			x = y
+		}
+	}
+	return sum
+}
+
+// nodeWeight returns the weight x adds to the sums: that of its item, or 0
+// once soft deleted.
+func (sl *SkipList) nodeWeight(x *node) float64 {
+	if x.dead {
+		return 0
+	}
+	return sl.weight(x.item)
+}
+
+// TotalWeight returns the sum of the weights of the items of a skip list
+// created by NewWeighted.
+func (sl *SkipList) TotalWeight() float64 {
+	return sl.totalWeight
+}
+
+// Insert adds the given item to the skip list. An existing item equal to
+// the given one is replaced.
+func (sl *SkipList) Insert(item Item) {
+	sl.insert(item, nil, nil)
+}
+
+// InsertMerge adds the given item to the skip list. If an equal item is
+// already present, combine(existing, item) is stored in its place.
+func (sl *SkipList) InsertMerge(item Item, combine func(old, new Item) Item) {
+	sl.insert(item, combine, nil)
+}
+
+// InsertIf adds the given item to the skip list. If an equal item is already
+// present, it is replaced only if replace(existing, item) returns true;
+// otherwise the insert changes nothing, not even the version or Stats.
+func (sl *SkipList) InsertIf(item Item, replace func(old, new Item) bool) {
+	sl.insert(item, nil, replace)
+}
+
+// TryInsertItem is like Insert but returns ErrNilItem for a nil item and an
+// error wrapping ErrIncomparable if comparing the item panics, instead of
+// panicking. The skip list is left unchanged on error.
+func (sl *SkipList) TryInsertItem(item Item) (err error) {
+	if item == nil {
+		return ErrNilItem
+	}
+	defer func() {
+		if r := recover(); r == ErrFull {
+			err = ErrFull
+		} else if r != nil {
+			err = fmt.Errorf("%w %v: %v", ErrIncomparable, item, r)
+		}
+	}()
+	sl.insert(item, nil, nil)
+	return nil
+}
+
+// insert adds item, or stores combine(existing, item), or item if combine is
+// nil, in place of an equal one. If replace is set and returns false, the
+// existing item is left alone and the insert has no effect at all.
+func (sl *SkipList) insert(item Item, combine func(old, new Item) Item, replace func(old, new Item) bool) {
+	if item == nil {
+		panic(ErrNilItem)
+	}
+	var staticAlloc [DefaultMaxLevel]*node
+	var prev = staticAlloc[:sl.maxLevel]
+	var rank [DefaultMaxLevel]int      // rank[i] is the position of prev[i]
+	var wrank [DefaultMaxLevel]float64 // wrank[i] is the weight up to prev[i]
+	x := sl.header
+	k := sl.probe(item)
+	var stop *node // does not go before item, see Search
+	for i := sl.level - 1; i >= 0; i-- {
+		if i < sl.level-1 {
+			rank[i] = rank[i+1]
+			wrank[i] = wrank[i+1]
+		}
+		for y := x.forward[i]; y != stop && sl.nodePlacesAfter(y, k); y = x.forward[i] {
+			rank[i] += x.span[i]
+			if sl.weight != nil {
+				wrank[i] += x.wsum[i]
+			}
+			x = y
+		}
+		prev[i] = x
+		stop = x.forward[i]
+	}
+	x = x.forward[0]
+	if x != nil && !sl.probeLess(k, x) {
+		var old float64 // weight of x in the sums
+		if sl.weight != nil {
+			old = sl.nodeWeight(x)
+		}
+		if x.dead {
+			x.dead = false
+			sl.tombstones--
+			sl.stats.Inserts++
+		} else {
+			if replace != nil && !replace(x.item, item) {
+				return
+			}
+			if sl.onOverwrite != nil {
+				sl.onOverwrite(item)
+			}
+			if combine != nil {
+				item = combine(x.item, item)
+			}
+			sl.stats.Overwrites++
+		}
+		if sl.weight != nil {
+			d := sl.weight(item) - old
+			for i := int32(0); i < sl.level; i++ {
+				prev[i].wsum[i] += d
+			}
+			sl.totalWeight += d
+		}
+		sl.setItem(x, item, k)
+		sl.stamp(x)
+	} else {
+		if sl.full() {
+			panic(ErrFull)
+		}
+		lvl := sl.randomLevel()
+		if lvl > sl.level {
+			for i := sl.level; i < lvl; i++ {
+				prev[i] = sl.header
+				rank[i] = 0
+				wrank[i] = 0
+				sl.header.span[i] = sl.length
+				if sl.weight != nil {
+					sl.header.wsum[i] = sl.totalWeight
+				}
+			}
+			sl.level = lvl
+		}
+
+		x = sl.newNode(lvl)
+		sl.setItem(x, item, k)
+		sl.stamp(x)
+		for i := int32(0); i < lvl; i++ {
+			x.forward[i], prev[i].forward[i] = prev[i].forward[i], x
+			x.span[i] = prev[i].span[i] - (rank[0] - rank[i])
+			prev[i].span[i] = rank[0] - rank[i] + 1
+		}
+		for i := lvl; i < sl.level; i++ {
+			prev[i].span[i]++
+		}
+		sl.linkBackward(x, prev[0])
+		if sl.bloom != nil {
+			sl.bloom.add(item)
+		}
+		if sl.weight != nil {
+			w := sl.weight(item)
+			for i := int32(0); i < lvl; i++ {
+				x.wsum[i] = prev[i].wsum[i] - (wrank[0] - wrank[i])
+				prev[i].wsum[i] = wrank[0] - wrank[i] + w
+			}
+			for i := lvl; i < sl.level; i++ {
+				prev[i].wsum[i] += w
+			}
+			sl.totalWeight += w
+		}
+		sl.length++
+		sl.stats.Inserts++
+		if sl.adaptive {
+			sl.fit(sl.length)
+		}
+	}
+	if sl.maintain {
+		sl.lowerTop()
+	}
+}
+
+// Delete remote an item equal to the passed in item. return true if success, else false.
+func (sl *SkipList) Delete(item Item) bool {
+	_, ok := sl.delete(item, false)
+	return ok
+}
+
+// DeleteAndNext removes an item equal to item and returns the item that
+// followed it, or nil if it was the last, so that a scan can resume where the
+// removed item was without searching again. It returns nil, false if no item
+// equals item.
+func (sl *SkipList) DeleteAndNext(item Item) (next Item, deleted bool) {
+	x, ok := sl.delete(item, false)
+	if x != nil {
+		next = sl.out(x.item)
+	}
+	return next, ok
+}
+
+// DeleteCompact is like Delete but drops the node of the item for the
+// garbage collector instead of recycling it, so its slices, which may be
+// sized for many levels, are not kept in the free list. Use it while
+// shrinking a list for good. Pinned nodes and deferred frees are still
+// honoured, and a list made by NewFixed keeps its nodes in the slab.
+func (sl *SkipList) DeleteCompact(item Item) bool {
+	_, ok := sl.delete(item, true)
+	return ok
+}
+
+// delete removes an item equal to item, dropping its node rather than
+// recycling it if drop is true. It returns the first live node after it, if
+// any, and whether an item was removed.
+func (sl *SkipList) delete(item Item, drop bool) (*node, bool) {
+	if item == nil {
+		panic(ErrNilItem)
+	}
+	var staticAlloc [DefaultMaxLevel]*node
+	var prev = staticAlloc[:sl.maxLevel]
+	x := sl.header
+	k := sl.probe(item)
+	var stop *node // not less than item, see Search
+	for i := sl.level - 1; i >= 0; i-- {
+		for y := x.forward[i]; y != stop && sl.nodeLess(y, k); y = x.forward[i] {
+			x = y
+		}
+		prev[i] = x
+		stop = x.forward[i]
+	}
+	x = x.forward[0]
+	if x != nil && x.dead && sl.multi {
+		// remove the first equal item not soft deleted, found by position
+		rank := sl.CountLess(item) + 1
+		y := x
+		for ; y != nil && y.dead && !sl.less(item, y.item); y = y.forward[0] {
+			rank++
+		}
+		if y != nil && !sl.less(item, y.item) {
+			x = y
+			sl.prevByRank(rank, prev)
+		}
+	}
+	if x != nil && !sl.probeLess(k, x) {
+		dead := x.dead
+		next := x.forward[0]
+		for next != nil && next.dead {
+			next = next.forward[0]
+		}
+		sl.unlinkNode(x, prev)
+		if drop && sl.pins[x] == nil && !sl.deferFree && !sl.freelist.fixed {
+			x.gen++
+		} else {
+			sl.release(x)
+		}
+		if sl.maintain {
+			sl.lowerTop()
+		}
+		if !dead {
+			return next, true
+		}
+	}
+	sl.stats.DeleteMisses++
+	return nil, false
+}
+
+// SoftDelete marks the item equal to key as deleted without unlinking it.
+// The item disappears from Search, iteration and Len at once, while its node
+// is only reclaimed by Compact, amortizing the structural work of many
+// deletes. Positions skip soft deleted items, but GetByRank, Median,
+// AtFraction, RangeByRank, NthGreater and RangeBounds then walk the list in
+// O(n). Weights drop the item at once too. Counts such as CountLess still
+// see soft deleted items until Compact. It returns false if no item equals
+// key.
+func (sl *SkipList) SoftDelete(key Item) bool {
+	if key == nil {
+		panic(ErrNilItem)
+	}
+	x := sl.skipDead(sl.searchNode(key), key)
+	if x == nil || sl.less(key, x.item) || x.dead {
+		return false
+	}
+	if sl.weight != nil {
+		// take its weight out of the sums covering it, found by position
+		rank := sl.CountLess(key) + 1
+		for y := sl.searchNode(key); y != x; y = y.forward[0] {
+			rank++
+		}
+		var staticAlloc [DefaultMaxLevel]*node
+		var prev = staticAlloc[:sl.maxLevel]
+		sl.prevByRank(rank, prev)
+		w := sl.weight(x.item)
+		for i := int32(0); i < sl.level; i++ {
+			prev[i].wsum[i] -= w
+		}
+		sl.totalWeight -= w
+	}
+	x.dead = true
+	sl.tombstones++
+	return true
+}
+
+// Compact unlinks all soft deleted items in one pass and returns their number.
+func (sl *SkipList) Compact() int {
+	n := sl.tombstones
+	if n == 0 {
+		return 0
+	}
+	var staticAlloc [DefaultMaxLevel]*node
+	var prev = staticAlloc[:sl.maxLevel]
+	for i := range prev {
+		prev[i] = sl.header
+	}
+	for x := sl.header.forward[0]; x != nil && sl.tombstones > 0; {
+		next := x.forward[0]
+		if x.dead {
+			sl.removeNode(x, prev)
+		} else {
+			for i := range x.forward {
+				prev[i] = x
+			}
+		}
+		x = next
+	}
+	return n
+}
+
+// PopLE removes and returns the greatest item less than or equal to key. It
+// returns false if there is no such item.
+func (sl *SkipList) PopLE(key Item) (Item, bool) {
+	if key == nil {
+		panic(ErrNilItem)
+	}
+	var staticAlloc [DefaultMaxLevel]*node
+	var prev = staticAlloc[:sl.maxLevel]
+	x := sl.header
+	// loop : x→forward[0]→key <= key, so x comes before the floor of key
+	for i := sl.level - 1; i >= 0; i-- {
+		for y := x.forward[i]; y != nil && y.forward[0] != nil && !sl.less(key, y.forward[0].item); y = x.forward[i] {
+			x = y
+		}
+		prev[i] = x
+	}
+	x = x.forward[0]
+	if x == nil || sl.less(key, x.item) {
+		return nil, false
+	}
+	if x.dead {
+		// step back to the last live node, found again by its position
+		rank := sl.CountGreater(key)
+		for ; x != nil && x.dead; x = x.backward {
+			rank++
+		}
+		if x == nil {
+			return nil, false
+		}
+		sl.prevByRank(sl.length-rank, prev)
+	}
+	item := x.item
+	sl.removeNode(x, prev)
+	return item, true
+}
+
+// prevByRank fills prev with the nodes before the given 1-based position on
+// every level, for removing the node there.
+func (sl *SkipList) prevByRank(rank int, prev []*node) {
+	var traversed int
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.forward[i] != nil && traversed+x.span[i] < rank {
+			traversed += x.span[i]
+			x = x.forward[i]
+		}
+		prev[i] = x
+	}
+}
+
+// popFront removes and returns the first item, or nil if the list is empty.
+func (sl *SkipList) popFront() Item {
+	x := sl.header.forward[0]
+	if x == nil {
+		return nil
+	}
+	var staticAlloc [DefaultMaxLevel]*node
+	var prev = staticAlloc[:sl.maxLevel]
+	for i := range prev {
+		prev[i] = sl.header
+	}
+	item := x.item
+	sl.removeNode(x, prev)
+	return item
+}
+
+// removeNode unlinks x, whose predecessor on every level is in prev, and
+// recycles it.
+func (sl *SkipList) removeNode(x *node, prev []*node) {
+	sl.unlinkNode(x, prev)
+	sl.release(x)
+}
+
+// unlinkNode removes x, whose predecessor on every level is in prev, from the
+// list, leaving its fate to the caller.
+func (sl *SkipList) unlinkNode(x *node, prev []*node) {
+	if sl.weight != nil {
+		w := sl.nodeWeight(x)
+		for i := int32(0); i < sl.level; i++ {
+			if prev[i].forward[i] == x {
+				prev[i].wsum[i] += x.wsum[i] - w
+			} else {
+				prev[i].wsum[i] -= w
+			}
+		}
+		sl.totalWeight -= w
+	}
+	for i := int32(0); i < sl.level; i++ {
+		if prev[i].forward[i] == x {
+			prev[i].span[i] += x.span[i] - 1
+			prev[i].forward[i] = x.forward[i]
+		} else {
+			prev[i].span[i]--
+		}
+	}
+	if next := x.forward[0]; next != nil {
+		next.backward = x.backward
+	}
+	if sl.bloom != nil {
+		sl.bloom.remove(x.item)
+	}
+	if x.dead {
+		sl.tombstones--
+	}
+	sl.length--
+	sl.stats.Deletes++
+	sl.shrinkLevel()
+}
+
+// InsertWindow inserts item and then removes the items less than olderThan,
+// unlinking them in one pass, and returns their number. Inserting increasing
+// timestamps with olderThan trailing them by a fixed window keeps just the
+// recent ones.
+func (sl *SkipList) InsertWindow(item, olderThan Item) int {
+	if item == nil || olderThan == nil {
+		panic(ErrNilItem)
+	}
+	sl.Insert(item)
+	return sl.trimFront(olderThan)
+}
+
+// Retain removes all items outside [begin, end] and returns how many were
+// removed. The leading and trailing runs are each unlinked in one pass.
+func (sl *SkipList) Retain(begin, end Item) int {
+	if begin == nil || end == nil {
+		panic(ErrNilItem)
+	}
+	return sl.trimFront(begin) + sl.trimBack(end)
+}
+
+// PopMinN removes and returns the n smallest items in ascending order, or all
+// of them if the list holds fewer. They are unlinked from the front in one
+// pass rather than deleted one by one, and their nodes are recycled.
+// Soft-deleted items among the first n are removed too but not returned.
+func (sl *SkipList) PopMinN(n int) []Item {
+	if n > sl.length {
+		n = sl.length
+	}
+	if n <= 0 {
+		return nil
+	}
+	items := make([]Item, 0, n)
+	x := sl.header.forward[0]
+	for i := 0; i < n; i++ {
+		if !x.dead {
+			items = append(items, x.item)
+		}
+		x = x.forward[0]
+	}
+	sl.cutFront(func(y *node, rank int) bool {
+		return rank <= n
+	})
+	return items
+}
+
+// trimFront removes the items less than key and returns their number.
+func (sl *SkipList) trimFront(key Item) int {
+	return sl.cutFront(func(y *node, rank int) bool {
+		return sl.less(y.item, key)
+	})
+}
+
+// cutFront removes the leading nodes for which before, given each node and
+// its 1-based rank, returns true, and returns their number.
+func (sl *SkipList) cutFront(before func(y *node, rank int) bool) int {
+	var staticAlloc [DefaultMaxLevel]*node
+	var prev = staticAlloc[:sl.maxLevel]
+	var rank [DefaultMaxLevel]int
+	var wrank [DefaultMaxLevel]float64
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		if i < sl.level-1 {
+			rank[i] = rank[i+1]
+			wrank[i] = wrank[i+1]
+		}
+		for y := x.forward[i]; y != nil && before(y, rank[i]+x.span[i]); y = x.forward[i] {
+			rank[i] += x.span[i]
+			if sl.weight != nil {
+				wrank[i] += x.wsum[i]
+			}
+			x = y
+		}
+		prev[i] = x
+	}
+	n := rank[0]
+	if n == 0 {
+		return 0
+	}
+	first := sl.header.forward[0]
+	if next := prev[0].forward[0]; next != nil {
+		next.backward = nil
+	}
+	for i := int32(0); i < sl.level; i++ {
+		sl.header.forward[i] = prev[i].forward[i]
+		sl.header.span[i] = rank[i] + prev[i].span[i] - n
+		if sl.weight != nil {
+			sl.header.wsum[i] = wrank[i] + prev[i].wsum[i] - wrank[0]
+		}
+	}
+	sl.totalWeight -= wrank[0]
+	sl.freeRun(first, n)
+	sl.length -= n
+	sl.shrinkLevel()
+	return n
+}
+
+// trimBack removes the items greater than key and returns their number.
+func (sl *SkipList) trimBack(key Item) int {
+	var staticAlloc [DefaultMaxLevel]*node
+	var prev = staticAlloc[:sl.maxLevel]
+	var rank [DefaultMaxLevel]int
+	var wrank [DefaultMaxLevel]float64
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		if i < sl.level-1 {
+			rank[i] = rank[i+1]
+			wrank[i] = wrank[i+1]
+		}
+		for y := x.forward[i]; y != nil && !sl.less(key, y.item); y = x.forward[i] {
+			rank[i] += x.span[i]
+			if sl.weight != nil {
+				wrank[i] += x.wsum[i]
+			}
+			x = y
+		}
+		prev[i] = x
+	}
+	keep := rank[0]
+	n := sl.length - keep
+	if n == 0 {
+		return 0
+	}
+	first := prev[0].forward[0]
+	for i := int32(0); i < sl.level; i++ {
+		prev[i].forward[i] = nil
+		prev[i].span[i] = keep - rank[i]
+		if sl.weight != nil {
+			prev[i].wsum[i] = wrank[0] - wrank[i]
+		}
+	}
+	sl.totalWeight = wrank[0]
+	sl.freeRun(first, n)
+	sl.length = keep
+	sl.shrinkLevel()
+	return n
+}
+
+// freeRun recycles n nodes linked at level 0 starting with x, which the
+// caller has unlinked.
+func (sl *SkipList) freeRun(x *node, n int) {
+	sl.stats.Deletes += n
+	for ; n > 0; n-- {
+		next := x.forward[0]
+		if x.dead {
+			sl.tombstones--
+		}
+		if sl.bloom != nil {
+			sl.bloom.remove(x.item)
+		}
+		sl.release(x)
+		x = next
+	}
+}
+
+// shrinkLevel lowers the current level past the empty top levels.
+func (sl *SkipList) shrinkLevel() {
+	for sl.level > 1 && sl.header.forward[sl.level-1] == nil {
+		sl.level--
+	}
+}
+
+// Replace removes all items from the skip list and loads the given items,
+// which must be sorted in strictly ascending order. The nodes of the removed
+// items are reused for the new ones, unless deferred freeing is on: then
+// they wait for Collect like those of deletes, and new nodes are allocated.
+func (sl *SkipList) Replace(items []Item) {
+	for i, item := range items {
+		if item == nil {
+			panic(ErrNilItem)
+		}
+		if i > 0 && !sl.placesAfter(items[i-1], item) {
+			panic("items must be sorted in ascending order")
+		}
+	}
+	if sl.freelist.fixed {
+		free := len(sl.freelist.freelist)
+		if !sl.deferFree {
+			free += sl.length
+		}
+		if len(items) > free {
+			panic(ErrFull)
+		}
+	}
+
+	if sl.adaptive {
+		sl.fit(len(items))
+	}
+	old := sl.header.forward[0]
+	for i := range sl.header.forward {
+		sl.header.forward[i] = nil
+		sl.header.span[i] = 0
+	}
+	for i := range sl.header.wsum {
+		sl.header.wsum[i] = 0
+	}
+	sl.level = 1
+	sl.length = 0
+	sl.tombstones = 0
+	sl.totalWeight = 0
+	if sl.bloom != nil {
+		sl.bloom.reset()
+	}
+
+	var staticAlloc [DefaultMaxLevel]*node
+	var tail = staticAlloc[:sl.maxLevel]
+	for i := range tail {
+		tail[i] = sl.header
+	}
+	for _, item := range items {
+		lvl := sl.randomLevel()
+		var x *node
+		for old != nil && (sl.pins[old] != nil || sl.deferFree) {
+			sl.release(old)
+			old = old.forward[0]
+		}
+		if old != nil {
+			x, old = old, old.forward[0]
+			x.gen++
+			x.dead = false
+			x.resize(lvl)
+			if sl.weight != nil {
+				x.resizeWeights()
+			}
+		} else {
+			x = sl.newNode(lvl)
+		}
+		sl.setItem(x, item, sl.probe(item))
+		sl.stamp(x)
+		sl.pushBack(tail, x)
+		if sl.bloom != nil {
+			sl.bloom.add(item)
+		}
+	}
+	for old != nil {
+		x := old
+		old = old.forward[0]
+		sl.release(x)
+	}
+}
+
+// pin counts the pins of a node, see SkipList.Pin.
+type pin struct {
+	n        int
+	unlinked bool // removed from the list, to be freed on the last Unpin
+}
+
+// Pin keeps the node of the item equal to key from being recycled once the
+// item is removed, until as many Unpin calls. An Iterator on it thus stays
+// valid and keeps reading the item through a Delete; without a pin, using it
+// would panic. It returns false if no item equals key.
+func (sl *SkipList) Pin(key Item) bool {
+	if key == nil {
+		panic(ErrNilItem)
+	}
+	x := sl.skipDead(sl.searchNode(key), key)
+	if x == nil || sl.less(key, x.item) || x.dead {
+		return false
+	}
+	if sl.pins == nil {
+		sl.pins = make(map[*node]*pin)
+	}
+	p := sl.pins[x]
+	if p == nil {
+		p = &pin{}
+		sl.pins[x] = p
+	}
+	p.n++
+	return true
+}
+
+// Unpin undoes a Pin of an item equal to key, preferring one already removed
+// from the list. A removed item's node is recycled on its last Unpin, which
+// invalidates iterators on it. It returns false if no pinned item equals key.
+func (sl *SkipList) Unpin(key Item) bool {
+	if key == nil {
+		panic(ErrNilItem)
+	}
+	var x *node
+	for y, p := range sl.pins {
+		if !sl.less(key, y.item) && !sl.less(y.item, key) && (x == nil || p.unlinked) {
+			x = y
+		}
+	}
+	if x == nil {
+		return false
+	}
+	p := sl.pins[x]
+	if p.n--; p.n == 0 {
+		delete(sl.pins, x)
+		if p.unlinked {
+			sl.freelist.freeNode(x)
+		}
+	}
+	return true
+}
+
+// release recycles x, just removed from the list, unless it is pinned or
+// frees are deferred.
+func (sl *SkipList) release(x *node) {
+	if p := sl.pins[x]; p != nil {
+		p.unlinked = true
+		return
+	}
+	if sl.deferFree {
+		sl.pending = append(sl.pending, x)
+		return
+	}
+	sl.freelist.freeNode(x)
+}
+
+// SetDeferredFree turns deferred freeing on or off. While it is on, removed
+// nodes keep their item and links, and iterators on them stay valid, until
+// the next Collect, giving readers a grace period after a delete. This is an
+// advanced aid for schemes that control when readers may still hold nodes;
+// the skip list itself is still not safe for concurrent use. Turning it off
+// collects the pending nodes.
+func (sl *SkipList) SetDeferredFree(on bool) {
+	sl.deferFree = on
+	if !on {
+		sl.Collect()
+	}
+}
+
+// Collect recycles the nodes removed since deferred freeing was turned on or
+// since the previous Collect, and returns their number.
+func (sl *SkipList) Collect() int {
+	n := len(sl.pending)
+	for i, x := range sl.pending {
+		sl.freelist.freeNode(x)
+		sl.pending[i] = nil
+	}
+	sl.pending = sl.pending[:0]
+	return n
+}
+
+// NewFromSorted creates a skip list holding items, which must be sorted in
+// strictly ascending order, in O(n) time.
+func NewFromSorted(items []Item) *SkipList {
+	sl := New()
+	sl.Replace(items)
+	return sl
+}
+
+// NewFromSortedCombine is like NewFromSorted but items need only be in
+// ascending order: each run of equal items is folded left to right through
+// combine, which must return an item equal to its arguments, and stored as
+// one item.
+func NewFromSortedCombine(items []Item, combine func(a, b Item) Item) *SkipList {
+	sl := New()
+	var staticAlloc [DefaultMaxLevel]*node
+	var tail = staticAlloc[:sl.maxLevel]
+	for i := range tail {
+		tail[i] = sl.header
+	}
+	for _, item := range items {
+		if item == nil {
+			panic(ErrNilItem)
+		}
+		if last := tail[0]; last != sl.header {
+			if sl.less(item, last.item) {
+				panic("items must be sorted in ascending order")
+			}
+			if !sl.less(last.item, item) {
+				last.item = combine(last.item, item)
+				continue
+			}
+		}
+		x := sl.newNode(sl.randomLevel())
+		x.item = item
+		sl.pushBack(tail, x)
+	}
+	return sl
+}
+
+// BuildFromChannel creates a skip list from the items received on ch until
+// it is closed. If sorted is true, the items must come in strictly ascending
+// order and each is appended after the last one in O(1); otherwise they are
+// inserted, equal ones replacing each other.
+func BuildFromChannel(ch <-chan Item, sorted bool) *SkipList {
+	sl := New()
+	if !sorted {
+		for item := range ch {
+			sl.Insert(item)
+		}
+		return sl
+	}
+	var staticAlloc [DefaultMaxLevel]*node
+	var tail = staticAlloc[:sl.maxLevel]
+	for i := range tail {
+		tail[i] = sl.header
+	}
+	for item := range ch {
+		if item == nil {
+			panic(ErrNilItem)
+		}
+		if tail[0] != sl.header && !sl.placesAfter(tail[0].item, item) {
+			panic("items must be sorted in ascending order")
+		}
+		x := sl.newNode(sl.randomLevel())
+		x.item = item
+		sl.pushBack(tail, x)
+	}
+	return sl
+}
+
+// SetIncrementalMaintenance turns on or off the lowering of tall nodes by
+// inserts and deletes. With it on, while the level is more than one above
+// ExpectedLevel, as after deleting most of the short nodes, each Insert and
+// Delete takes up to two nodes off the top level, each in O(1), so the level
+// settles back without the pause of ShrinkToFit. The overhead is that of
+// computing ExpectedLevel, O(log n) arithmetic, per operation. Lowered nodes
+// only lose express lanes; unlike ShrinkToFit it never raises short nodes or
+// lowers the max level.
+func (sl *SkipList) SetIncrementalMaintenance(on bool) {
+	sl.maintain = on
+}
+
+// lowerTop takes up to maintainSteps nodes off the top level while the level
+// exceeds ExpectedLevel by more than one. The first node of the top level has
+// the header as predecessor there, so it is unlinked in O(1).
+func (sl *SkipList) lowerTop() {
+	target := sl.ExpectedLevel() + 1
+	for n := 0; n < maintainSteps && sl.level > target; n++ {
+		top := sl.level - 1
+		x := sl.header.forward[top]
+		sl.header.forward[top] = x.forward[top]
+		sl.header.span[top] += x.span[top]
+		if sl.weight != nil {
+			sl.header.wsum[top] += x.wsum[top]
+			x.wsum = x.wsum[:top]
+		}
+		x.forward[top] = nil
+		x.resize(top)
+		sl.shrinkLevel()
+	}
+}
+
+// ShrinkToFit rebuilds the skip list with the smallest max level suited to
+// its current length, (1/P)^maxLevel >= Len(), reusing its nodes. After many
+// deletes this drops the tall leftover nodes and the unused header levels.
+// Soft deleted items are dropped too. The max level is never raised; on a
+// list that is not adaptive it stays lowered for later inserts.
+func (sl *SkipList) ShrinkToFit() {
+	lvl := sl.ExpectedLevel()
+	if lvl > sl.maxLevel {
+		lvl = sl.maxLevel
+	}
+	items := make([]Item, 0, sl.Len())
+	for it := sl.NewIterator(); it.Valid(); it.Next() {
+		items = append(items, it.Value())
+	}
+	for i := lvl; i < sl.maxLevel; i++ {
+		sl.header.forward[i] = nil
+	}
+	sl.maxLevel = lvl
+	sl.header.forward = sl.header.forward[:lvl]
+	sl.header.span = sl.header.span[:lvl]
+	if sl.weight != nil {
+		sl.header.wsum = sl.header.wsum[:lvl]
+	}
+	sl.Replace(items)
+}
+
+// Repair relinks every level above 0, and recomputes the spans and backward
+// links, from the level 0 chain and the height of each node, keeping the
+// items and the heights. It restores a list whose express lanes were broken,
+// for instance by loading corrupt data, as long as level 0 is intact.
+func (sl *SkipList) Repair() {
+	x := sl.header.forward[0]
+	for i := range sl.header.forward {
+		sl.header.forward[i] = nil
+		sl.header.span[i] = 0
+	}
+	for i := range sl.header.wsum {
+		sl.header.wsum[i] = 0
+	}
+	sl.level = 1
+	sl.length = 0
+	sl.totalWeight = 0
+
+	var staticAlloc [DefaultMaxLevel]*node
+	var tail = staticAlloc[:sl.maxLevel]
+	for i := range tail {
+		tail[i] = sl.header
+	}
+	for x != nil {
+		next := x.forward[0]
+		if len(x.forward) > int(sl.maxLevel) {
+			x.resize(sl.maxLevel)
+		}
+		sl.pushBack(tail, x)
+		x = next
+	}
+}
+
+// pushBack links x after the last node. tail holds the last node of every
+// level and is advanced to x.
+func (sl *SkipList) pushBack(tail []*node, x *node) {
+	lvl := int32(len(x.forward))
+	if lvl > sl.level {
+		sl.level = lvl
+	}
+	for i := range tail {
+		tail[i].span[i]++
+	}
+	if sl.weight != nil {
+		w := sl.nodeWeight(x)
+		for i := range tail {
+			tail[i].wsum[i] += w
+		}
+		for i := range x.wsum {
+			x.wsum[i] = 0
+		}
+		sl.totalWeight += w
+	}
+	x.backward = nil
+	if tail[0] != sl.header {
+		x.backward = tail[0]
+	}
+	for i := int32(0); i < lvl; i++ {
+		x.forward[i] = nil
+		x.span[i] = 0
+		tail[i].forward[i] = x
+		tail[i] = x
+	}
+	sl.length++
+}
+
+// linkBackward sets the backward links around x, just linked after prev at
+// level 0.
+func (sl *SkipList) linkBackward(x, prev *node) {
+	if prev == sl.header {
+		prev = nil
+	}
+	x.backward = prev
+	if next := x.forward[0]; next != nil {
+		next.backward = x
+	}
+}
+
+// lastNode returns the last node, or nil if the list is empty.
+func (sl *SkipList) lastNode() *node {
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.forward[i] != nil {
+			x = x.forward[i]
+		}
+	}
+	if x == sl.header {
+		return nil
+	}
+	return x
+}
+
+// MaxSearchDepth returns the largest number of forward hops any search
+// currently takes, in one O(n) pass over the nodes. It is about
+// (1/P)*log(n)/log(1/P) for a well formed list and grows towards n when the
+// node levels degrade, for example because of a poor level function.
+func (sl *SkipList) MaxSearchDepth() int {
+	// A search hops, on each level i, over the nodes of height i+1 since the
+	// last node higher than that, so cnt[i] counts these nodes.
+	var cnt [DefaultMaxLevel]int
+	max := 0
+	depth := func() int {
+		d := 0
+		for _, c := range cnt[:sl.level] {
+			d += c
+		}
+		return d
+	}
+	for x := sl.header.forward[0]; x != nil; x = x.forward[0] {
+		if d := depth(); d > max {
+			max = d
+		}
+		h := len(x.forward)
+		cnt[h-1]++
+		for i := 0; i < h-1; i++ {
+			cnt[i] = 0
+		}
+	}
+	if d := depth(); d > max {
+		max = d
+	}
+	return max
+}
+
+// ExpectedLevel returns the level suited to the current length, the least l
+// such that (1/P)^l >= Len(), and at least 1. It ignores the max level.
+func (sl *SkipList) ExpectedLevel() int32 {
+	lvl := int32(1)
+	for float64(sl.Len()) > math.Pow(1/float64(sl.prob()), float64(lvl)) {
+		lvl++
+	}
+	return lvl
+}
+
+// CheckLevel returns ErrTooTall if the current level exceeds ExpectedLevel
+// by more than a fair level generator plausibly would, which happens with a
+// biased or broken generator, such as a rand.Rand given to NewWithRand.
+// Such a list stays correct, as levels never exceed the max level, but its
+// operations slow down. Calling it now and then flags the problem.
+func (sl *SkipList) CheckLevel() error {
+	if sl.level > sl.ExpectedLevel()+tallSlack {
+		return fmt.Errorf("%w: level %d for %d items", ErrTooTall, sl.level, sl.Len())
+	}
+	return nil
+}
+
+// IsSorted reports whether the items are still in ascending order, which
+// may not hold if the keys of stored items were mutated in place.
+func (sl *SkipList) IsSorted() bool {
+	x := sl.header.forward[0]
+	if x == nil {
+		return true
+	}
+	for y := x.forward[0]; y != nil; x, y = y, y.forward[0] {
+		if sl.less(y.item, x.item) {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate checks the invariants of the skip list: the items are in order,
+// every level links exactly the nodes tall enough for it, the spans,
+// backward links, length, level and tombstone count agree with the links,
+// and no node sits in the free list while still linked. It returns nil for a
+// sound list and an error wrapping ErrCorrupt otherwise. It takes O(n) time
+// and allocates a map of the nodes.
+func (sl *SkipList) Validate() error {
+	rank := map[*node]int{sl.header: 0}
+	heights := make([]int, sl.level+1)
+	var prev *node
+	n, dead := 0, 0
+	for x := sl.header.forward[0]; x != nil; prev, x = x, x.forward[0] {
+		if _, ok := rank[x]; ok {
+			return fmt.Errorf("%w: level 0 loops back to %v", ErrCorrupt, x.item)
+		}
+		n++
+		rank[x] = n
+		if x.dead {
+			dead++
+		}
+		switch {
+		case x.item == nil:
+			return fmt.Errorf("%w: nil item at rank %d", ErrCorrupt, n)
+		case x.backward != prev:
+			return fmt.Errorf("%w: wrong backward link at %v", ErrCorrupt, x.item)
+		case int32(len(x.forward)) > sl.level:
+			return fmt.Errorf("%w: node %v above the level %d", ErrCorrupt, x.item, sl.level)
+		case len(x.forward) == 0 || len(x.span) != len(x.forward):
+			return fmt.Errorf("%w: malformed node %v", ErrCorrupt, x.item)
+		case prev != nil && (sl.less(x.item, prev.item) || !sl.multi && !sl.less(prev.item, x.item)):
+			return fmt.Errorf("%w: %v out of order after %v", ErrCorrupt, x.item, prev.item)
+		}
+		heights[len(x.forward)]++
+	}
+	if n != sl.length {
+		return fmt.Errorf("%w: length %d but %d nodes", ErrCorrupt, sl.length, n)
+	}
+	if dead != sl.tombstones {
+		return fmt.Errorf("%w: %d tombstones but %d dead nodes", ErrCorrupt, sl.tombstones, dead)
+	}
+	tall := n // nodes with more than i levels
+	for i := int32(0); i < sl.level; i++ {
+		tall -= heights[i]
+		linked := 0
+		for x := sl.header; x != nil; linked++ {
+			y := x.forward[i]
+			want := n - rank[x]
+			if y != nil {
+				r, ok := rank[y]
+				if !ok || r <= rank[x] || int32(len(y.forward)) <= i {
+					return fmt.Errorf("%w: bad link at level %d after rank %d", ErrCorrupt, i, rank[x])
+				}
+				want = r - rank[x]
+			}
+			if x.span[i] != want {
+				return fmt.Errorf("%w: span %d at level %d after rank %d, want %d", ErrCorrupt, x.span[i], i, rank[x], want)
+			}
+			x = y
+		}
+		if linked-1 != tall {
+			return fmt.Errorf("%w: %d nodes linked at level %d, want %d", ErrCorrupt, linked-1, i, tall)
+		}
+	}
+	if sl.level > 1 && sl.header.forward[sl.level-1] == nil {
+		return fmt.Errorf("%w: empty top level %d", ErrCorrupt, sl.level)
+	}
+	for _, x := range sl.freelist.freelist {
+		if _, ok := rank[x]; ok {
+			return fmt.Errorf("%w: linked node %v in the free list", ErrCorrupt, x.item)
+		}
+	}
+	return nil
+}
+
+// SetLevelFunc makes the skip list take the level of new nodes from f
+// instead of drawing it at random. Levels returned by f are clamped to
+// [1, max level], or the cap set by SetMaxNodeLevel. A nil f restores the
+// random levels.
+func (sl *SkipList) SetLevelFunc(f func() int32) {
+	sl.levelFunc = f
+}
+
+// SetMaxNodeLevel caps the height of new nodes at n levels, below the max
+// level of the skip list. This bounds the memory of every node, whatever the
+// random draws, at the cost of slightly longer searches once the list
+// outgrows (1/P)^n items. Zero removes the cap.
+func (sl *SkipList) SetMaxNodeLevel(n int32) {
+	if n < 0 || n > DefaultMaxLevel {
+		panic("maxNodeLevel must be between 0 and DefaultMaxLevel")
+	}
+	sl.maxNodeLevel = n
+}
+
+func (sl *SkipList) randomLevel() int32 {
+	limit := sl.maxLevel
+	if sl.maxNodeLevel > 0 && sl.maxNodeLevel < limit {
+		limit = sl.maxNodeLevel
+	}
+	if sl.levelFunc != nil {
+		lvl := sl.levelFunc()
+		if lvl < 1 {
+			return 1
+		}
+		if lvl > limit {
+			return limit
+		}
+		return lvl
+	}
+	lvl, p := int32(1), sl.prob()
+	for lvl < limit && float32(sl.random.Uint32()&0xFFFF) < p*0xFFFF {
+		lvl++
+	}
+	return lvl
+}
+
+// prob returns the probability of raising a new node a level.
+func (sl *SkipList) prob() float32 {
+	if sl.p != 0 {
+		return sl.p
+	}
+	return DefaultP
+}
+
+// SetP sets the probability of raising a new node each level, DefaultP by
+// default. A lower p makes shorter nodes, saving memory, and longer searches.
+// Existing nodes keep their levels. It panics unless 0 < p < 1.
+func (sl *SkipList) SetP(p float32) {
+	if p <= 0 || p >= 1 {
+		panic("p must be between 0 and 1")
+	}
+	sl.p = p
+}
+
+func (sl *SkipList) Len() int {
+	return sl.length - sl.tombstones
+}
+
+// Stats returns the lifetime operation counters of the skip list.
+func (sl *SkipList) Stats() Stats {
+	return sl.stats
+}
+
+// SetItemsNoPointers tells whether the items hold no pointers, as with Int.
+// If so, nodes going back to the free list keep their item instead of having
+// it cleared, saving some work on every delete. It is unsafe for items that
+// hold pointers: the memory they reference would stay reachable from the
+// free list.
+func (sl *SkipList) SetItemsNoPointers(noPtrs bool) {
+	sl.freelist.noPtrs = noPtrs
+}
+
+// SetOverwriteCallback sets f to be called with the inserted item whenever an
+// insert replaces an equal item, the inserts Stats counts as Overwrites. A
+// nil f, the default, disables the callback.
+func (sl *SkipList) SetOverwriteCallback(f func(item Item)) {
+	sl.onOverwrite = f
+}
+
+// SetItemCopier makes the skip list pass the items it hands out through
+// copy: those returned by the searches, by the rank and range queries, and by
+// Value of the iterators and ranges, and those given to Range.ForEach. With
+// pointer items, copy can return a deep copy so that callers mutating
+// results cannot reorder the stored items behind the list's back. It costs a
+// call of copy, and usually an allocation, per item handed out. Items
+// removed from the list, and those given to the walking callbacks such as
+// WalkLevel or ForEachPair, are not copied. A nil copy turns copying off.
+func (sl *SkipList) SetItemCopier(copy func(Item) Item) {
+	sl.copier = copy
+}
+
+// out returns item as handed out to callers, see SetItemCopier.
+func (sl *SkipList) out(item Item) Item {
+	if sl.copier != nil {
+		return sl.copier(item)
+	}
+	return item
+}
+
+// PrewarmFreeList allocates n nodes up front so that subsequent inserts of
+// items up to maxNodeLevel high reuse them instead of allocating.
+func (sl *SkipList) PrewarmFreeList(n, maxNodeLevel int32) {
+	if maxNodeLevel < 1 || maxNodeLevel > sl.maxLevel {
+		panic("maxNodeLevel must be between 1 and the max level of the skip list")
+	}
+	sl.freelist.prewarm(int(n), maxNodeLevel)
+}
+
+// MaybeShrink releases the free nodes that went unused since the previous
+// call, and the free list capacity they took, returning their number. Called
+// periodically, it lets the free list follow the recent demand for nodes, so
+// that a spike of deletes or PrewarmFreeList does not pin memory for good
+// while the nodes a steady workload reuses are kept. The first call only
+// starts the first period. It releases nothing on a list created by NewFixed.
+func (sl *SkipList) MaybeShrink() int {
+	return sl.freelist.shrink()
+}
+
+// WalkLevel calls f for each item linked at the given level, in order.
+// Level 0 holds every item; higher levels are the express lanes.
+func (sl *SkipList) WalkLevel(level int32, f func(item Item)) {
+	if level < 0 || level >= sl.level {
+		panic("level must be between 0 and the current level of the skip list")
+	}
+	for x := sl.header.forward[level]; x != nil; x = x.forward[level] {
+		f(x.item)
+	}
+}
+
+// IterateUntil calls stop for each item from the least one not less than
+// start, in ascending order, until stop returns true or the items run out.
+// stop serves as the loop body and decides on the bound as it goes: the item
+// for which it returns true is the last one visited.
+func (sl *SkipList) IterateUntil(start Item, stop func(item Item) bool) {
+	if start == nil {
+		panic(ErrNilItem)
+	}
+	for x := sl.searchNode(start); x != nil; x = x.forward[0] {
+		if !x.dead && stop(x.item) {
+			return
+		}
+	}
+}
+
+// WalkDetailed calls f for each item in order with the items its node points
+// to on each of its levels, nil where a level ends, exposing the express
+// lanes to analysis tools. The forward slice is reused between calls.
+func (sl *SkipList) WalkDetailed(f func(item Item, forward []Item)) {
+	var forward []Item
+	for x := sl.header.forward[0]; x != nil; x = x.forward[0] {
+		forward = forward[:0]
+		for _, y := range x.forward {
+			if y == nil {
+				forward = append(forward, nil)
+			} else {
+				forward = append(forward, y.item)
+			}
+		}
+		f(x.item, forward)
+	}
+}
+
+// LevelExtents returns, for each level up to the current one, the first and
+// last items linked at that level, showing how the express lanes cover the
+// items. Level 0 spans all of them; a list without items has one pair of
+// nils.
+func (sl *SkipList) LevelExtents() [][2]Item {
+	extents := make([][2]Item, sl.level)
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.forward[i] != nil {
+			x = x.forward[i]
+		}
+		if x != sl.header {
+			extents[i] = [2]Item{sl.header.forward[i].item, x.item}
+		}
+	}
+	return extents
+}
+
+// ForEachPair calls f with each pair of adjacent items, in order: n-1 calls
+// for n items and none for fewer than two.
+func (sl *SkipList) ForEachPair(f func(a, b Item)) {
+	var prev *node
+	for x := sl.header.forward[0]; x != nil; x = x.forward[0] {
+		if x.dead {
+			continue
+		}
+		if prev != nil {
+			f(prev.item, x.item)
+		}
+		prev = x
+	}
+}
+
+// PairsWithin calls f with every pair of items a < b whose distance is less
+// than threshold, in order of a then b. As the items are sorted, the scan
+// from each item stops at the first one too far from it, so dist must grow
+// with the gap between items.
+func (sl *SkipList) PairsWithin(threshold float64, dist func(a, b Item) float64, f func(a, b Item)) {
+	for x := sl.header.forward[0]; x != nil; x = x.forward[0] {
+		if x.dead {
+			continue
+		}
+		for y := x.forward[0]; y != nil && dist(x.item, y.item) < threshold; y = y.forward[0] {
+			if !y.dead {
+				f(x.item, y.item)
+			}
+		}
+	}
+}
+
+// ForEachGroup calls f once for each run of equal items, in order, with the
+// first item of the run as key and agg applied to the whole run. Runs have
+// more than one item only in a multiset. The slice passed to agg is reused
+// between calls.
+func (sl *SkipList) ForEachGroup(agg func(items []Item) Item, f func(key, aggregated Item)) {
+	var group []Item
+	flush := func() {
+		if len(group) > 0 {
+			f(group[0], agg(group))
+			group = group[:0]
+		}
+	}
+	for x := sl.header.forward[0]; x != nil; x = x.forward[0] {
+		if x.dead {
+			continue
+		}
+		if len(group) > 0 && sl.less(group[0], x.item) {
+			flush()
+		}
+		group = append(group, x.item)
+	}
+	flush()
+}
+
+func (sl *SkipList) NewIterator() *Iterator {
+	return sl.NewIteratorSince(0)
+}
+
+// NewIteratorSince returns an iterator visiting only the items inserted or
+// overwritten at or after the given version. See NewVersioned.
+func (sl *SkipList) NewIteratorSince(version uint64) *Iterator {
+	it := &Iterator{sl: sl, since: version}
+	it.seek(sl.header.forward[0])
+	return it
+}
+
+// NewReverseIterator returns an iterator from the last item to the first,
+// following the backward links.
+func (sl *SkipList) NewReverseIterator() *Iterator {
+	it := &Iterator{sl: sl, reverse: true}
+	it.seek(sl.lastNode())
+	return it
+}
+
+// Ascending returns an iterator over the items in ascending order by their
+// Less method: a reverse iterator for a list created by NewDescending and a
+// plain one otherwise.
+func (sl *SkipList) Ascending() *Iterator {
+	if sl.descending {
+		return sl.NewReverseIterator()
+	}
+	return sl.NewIterator()
+}
+
+// NewRange returns a Range over the items in [begin, end]. The range is empty
+// if end is less than begin; see NewRangeSorted to accept the bounds in
+// either order.
+func (sl *SkipList) NewRange(begin, end Item) *Range {
+	r := &Range{}
+	sl.NewRangeInto(r, begin, end)
+	return r
+}
+
+// NewRangeSorted is like NewRange but swaps the bounds if b is less than a,
+// so it returns the items between a and b in either order.
+func (sl *SkipList) NewRangeSorted(a, b Item) *Range {
+	if a == nil || b == nil {
+		panic(ErrNilItem)
+	}
+	if sl.less(b, a) {
+		a, b = b, a
+	}
+	return sl.NewRange(a, b)
+}
+
+// NewRangeInto is like NewRange but reinitializes r instead of allocating a
+// new Range, so ranges can be pooled, for instance with a sync.Pool. Like any
+// Range, a reused one is only valid until the skip list is modified.
+func (sl *SkipList) NewRangeInto(r *Range, begin, end Item) {
+	if begin == nil || end == nil {
+		panic(ErrNilItem)
+	}
+	*r = Range{}
+	minNode := sl.header.forward[0]
+	if minNode == nil || sl.less(end, begin) {
+		return
+	}
+
+	beginNode := sl.searchNode(begin)
+	if beginNode == nil && sl.less(begin, minNode.item) {
+		beginNode = minNode
+	}
+
+	nend := sl.searchNode(end)
+	if nend == nil {
+		if sl.less(end, minNode.item) {
+			nend = minNode
+		}
+	} else {
+		if !sl.less(end, nend.item) {
+			nend = nend.forward[0]
+		}
+	}
+	*r = Range{
+		sl:    sl,
+		begin: beginNode,
+		end:   nend,
+		cur:   beginNode,
+	}
+	r.skipForward()
+}
+
+// GetRange returns the items in [begin, end] in ascending order.
+func (sl *SkipList) GetRange(begin, end Item) []Item {
+	var items []Item
+	sl.NewRange(begin, end).ForEach(func(item Item) {
+		items = append(items, item)
+	})
+	return items
+}
+
+// MultiRange returns the items in [q[0], q[1]] for each query q, like
+// GetRange. Queries sorted by their begin key share one descent: each one
+// resumes from the nodes where the previous one found its begin, climbing
+// only as high as the distance to its own begin calls for. On 10000 items a
+// batch of narrow nearby queries runs about a third faster than separate
+// GetRange calls; for wide ranges the walk dominates and the two are even.
+// A query out of order restarts from the header.
+func (sl *SkipList) MultiRange(queries [][2]Item) [][]Item {
+	out := make([][]Item, len(queries))
+	var staticAlloc [DefaultMaxLevel]*node
+	var prev = staticAlloc[:sl.maxLevel]
+	var last Item
+	var all []Item
+	for qi, q := range queries {
+		begin, end := q[0], q[1]
+		if begin == nil || end == nil {
+			panic(ErrNilItem)
+		}
+		if last == nil || sl.less(begin, last) {
+			for i := range prev {
+				prev[i] = sl.header
+			}
+		}
+		last = begin
+		// climb from the previous predecessors to the lowest level whose
+		// next node is not less than begin; the levels above stay valid
+		top := int32(0)
+		for top < sl.level-1 {
+			if y := prev[top].forward[top]; y == nil || !sl.less(y.item, begin) {
+				break
+			}
+			top++
+		}
+		x := prev[top]
+		for i := top; i >= 0; i-- {
+			for y := x.forward[i]; y != nil && sl.less(y.item, begin); y = x.forward[i] {
+				x = y
+			}
+			prev[i] = x
+		}
+		// the results share one growing backing array, capped per query
+		start := len(all)
+		for y := x.forward[0]; y != nil && !sl.less(end, y.item); y = y.forward[0] {
+			if !y.dead {
+				all = append(all, sl.out(y.item))
+			}
+		}
+		if len(all) > start {
+			out[qi] = all[start:len(all):len(all)]
+		}
+	}
+	return out
+}
+
+// GetRangeReverse returns the items in [begin, end] in descending order.
+func (sl *SkipList) GetRangeReverse(begin, end Item) []Item {
+	items := sl.GetRange(begin, end)
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
+	}
+	return items
+}
+
+// StreamRange sends the items in [begin, end] to ch in ascending order,
+// blocking while ch is full, so a slow consumer is fed without building a
+// slice of the range. It stops and returns ctx.Err() if ctx is done first,
+// and returns nil once the range is sent. ch is not closed. The skip list
+// must not be modified until StreamRange returns.
+func (sl *SkipList) StreamRange(ctx context.Context, begin, end Item, ch chan<- Item) error {
+	for r := sl.NewRange(begin, end); r.Valid(); r.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		select {
+		case ch <- r.Value():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// RangeBounds returns the first and last items in [begin, end] and their
+// number, in O(log n) using the spans instead of walking the range. It
+// returns nil, nil, 0 for an empty range.
+func (sl *SkipList) RangeBounds(begin, end Item) (first, last Item, count int) {
+	if begin == nil || end == nil {
+		panic(ErrNilItem)
+	}
+	if sl.less(end, begin) {
+		return nil, nil, 0
+	}
+	if sl.tombstones > 0 {
+		var l *node
+		for x := sl.searchNode(begin); x != nil && !sl.less(end, x.item); x = x.forward[0] {
+			if !x.dead {
+				if l == nil {
+					first = sl.out(x.item)
+				}
+				l = x
+				count++
+			}
+		}
+		if l != nil {
+			last = sl.out(l.item)
+		}
+		return first, last, count
+	}
+	lo := sl.CountLess(begin)
+	hi := sl.length - sl.CountGreater(end)
+	if hi <= lo {
+		return nil, nil, 0
+	}
+	return sl.out(sl.nodeByRank(lo + 1).item), sl.out(sl.nodeByRank(hi).item), hi - lo
+}
+
+// CountRangeFunc returns the number of items in [begin, end] for which pred
+// returns true, walking the range once. It returns 0 if end is less than
+// begin.
+func (sl *SkipList) CountRangeFunc(begin, end Item, pred func(Item) bool) int {
+	n := 0
+	sl.NewRange(begin, end).ForEach(func(item Item) {
+		if pred(item) {
+			n++
+		}
+	})
+	return n
+}
+
+// Diff compares the skip list with an older version of it. It returns the
+// items present now but not in old, and those present in old but not now,
+// both in ascending order, each copied by its list as SetItemCopier says.
+// The two lists are merge-walked once.
+func (sl *SkipList) Diff(old *SkipList) (added, removed []Item) {
+	x, y := sl.header.forward[0], old.header.forward[0]
+	for x != nil && y != nil {
+		switch {
+		case x.dead:
+			x = x.forward[0]
+		case y.dead:
+			y = y.forward[0]
+		case sl.less(x.item, y.item):
+			added = append(added, sl.out(x.item))
+			x = x.forward[0]
+		case sl.less(y.item, x.item):
+			removed = append(removed, old.out(y.item))
+			y = y.forward[0]
+		default:
+			x, y = x.forward[0], y.forward[0]
+		}
+	}
+	for ; x != nil; x = x.forward[0] {
+		if !x.dead {
+			added = append(added, sl.out(x.item))
+		}
+	}
+	for ; y != nil; y = y.forward[0] {
+		if !y.dead {
+			removed = append(removed, old.out(y.item))
+		}
+	}
+	return
+}
+
+// Iterator walks the skip list in ascending order. Items inserted while
+// iterating are visited if they sort after the current position. Deleting the
+// item the iterator is positioned at invalidates it: a following Next or Value
+// panics instead of reading a recycled node. MoveTo repositions an
+// invalidated iterator.
+type Iterator struct {
+	sl      *SkipList
+	x       *node
+	gen     uint32 // x.gen when the iterator moved to x
+	since   uint64 // items with a lower version are skipped
+	reverse bool   // walks from the last item to the first
+}
+
+func (it *Iterator) Valid() bool {
+	return it.x != nil
+}
+
+func (it *Iterator) Next() {
+	it.check()
+	it.seek(it.step(it.x))
+}
+
+func (it *Iterator) Value() Item {
+	it.check()
+	return it.sl.out(it.x.item)
+}
+
+// MoveTo moves to the first item not less than item or, for a reverse
+// iterator, to the last item not greater than item.
+func (it *Iterator) MoveTo(item Item) {
+	if item == nil {
+		panic(ErrNilItem)
+	}
+	x := it.sl.searchNode(item)
+	if it.reverse && (x == nil || it.sl.less(item, x.item)) {
+		if x == nil {
+			x = it.sl.lastNode()
+		} else {
+			x = x.backward
+		}
+	}
+	it.seek(x)
+}
+
+// step returns the node after x in the iteration order.
+func (it *Iterator) step(x *node) *node {
+	if it.reverse {
+		return x.backward
+	}
+	return x.forward[0]
+}
+
+func (it *Iterator) seek(x *node) {
+	for x != nil && (x.version < it.since || x.dead) {
+		x = it.step(x)
+	}
+	it.x = x
+	if x != nil {
+		it.gen = x.gen
+	}
+}
+
+func (it *Iterator) check() {
+	if it.x.gen != it.gen {
+		panic("skiplist: iterator used after its item was deleted")
+	}
+}
+
+// Checksum returns a fingerprint of the items in order, folding their hashes
+// into a 64-bit FNV-1a hash. Lists holding equal items have the same
+// checksum whatever the order they were built in, so replicas can compare
+// checksums to detect divergence.
+func (sl *SkipList) Checksum(hash func(Item) uint64) uint64 {
+	const (
+		offset64 = 14695981039346656037
+		prime64  = 1099511628211
+	)
+	sum := uint64(offset64)
+	for it := sl.NewIterator(); it.Valid(); it.Next() {
+		h := hash(it.Value())
+		for i := 0; i < 8; i++ {
+			sum ^= h & 0xff
+			sum *= prime64
+			h >>= 8
+		}
+	}
+	return sum
+}
+
+// IsSubsetOf reports whether every item of the skip list has an equal item
+// in other. Both lists are merge-walked once, in O(Len()+other.Len()),
+// stopping at the first item missing from other.
+func (sl *SkipList) IsSubsetOf(other *SkipList) bool {
+	return !NewDifferenceIterator(sl, other).Valid()
+}
+
+// DifferenceIterator walks the items of one skip list that have no equal item
+// in another, in ascending order.
+type DifferenceIterator struct {
+	sl   *SkipList
+	x, y *node
+}
+
+// NewDifferenceIterator returns an iterator over the items of a that are not
+// in b. Both lists are merge-walked once, in O(a.Len()+b.Len()).
+func NewDifferenceIterator(a, b *SkipList) *DifferenceIterator {
+	it := &DifferenceIterator{sl: a, x: a.header.forward[0], y: b.header.forward[0]}
+	it.skip()
+	return it
+}
+
+func (it *DifferenceIterator) Valid() bool {
+	return it.x != nil
+}
+
+func (it *DifferenceIterator) Next() {
+	it.x = it.x.forward[0]
+	it.skip()
+}
+
+func (it *DifferenceIterator) Value() Item {
+	return it.sl.out(it.x.item)
+}
+
+// skip advances x past the items that are also in the other list.
+func (it *DifferenceIterator) skip() {
+	for it.x != nil {
+		if it.x.dead {
+			it.x = it.x.forward[0]
+			continue
+		}
+		for it.y != nil && (it.y.dead || it.sl.less(it.y.item, it.x.item)) {
+			it.y = it.y.forward[0]
+		}
+		if it.y == nil || it.sl.less(it.x.item, it.y.item) {
+			return
+		}
+		it.x = it.x.forward[0]
+	}
+}
+
+// RandomIterator visits the items of a skip list in a random order, each
+// once.
+type RandomIterator struct {
+	sl    *SkipList
+	r     *rand.Rand
+	i     int
+	swaps map[int]int // the ranks moved by the shuffle so far
+	x     *node
+}
+
+// NewRandomIterator returns an iterator over the items in a random order
+// drawn from r. It shuffles the ranks lazily, drawing each from those not yet
+// drawn, so a step costs O(log n) and visiting k items takes O(k) memory
+// rather than a copy of the list.
+func (sl *SkipList) NewRandomIterator(r *rand.Rand) *RandomIterator {
+	it := &RandomIterator{sl: sl, r: r, swaps: make(map[int]int)}
+	it.Next()
+	return it
+}
+
+func (it *RandomIterator) Valid() bool {
+	return it.x != nil
+}
+
+func (it *RandomIterator) Next() {
+	it.x = nil
+	for n := it.sl.length; it.x == nil && it.i < n; it.i++ {
+		// One step of a Fisher-Yates shuffle of the ranks 0..n-1, keeping
+		// in swaps only the entries that differ from the identity.
+		j := it.i + it.r.Intn(n-it.i)
+		rank, ok := it.swaps[j]
+		if !ok {
+			rank = j
+		}
+		if moved, ok := it.swaps[it.i]; ok {
+			it.swaps[j] = moved
+		} else {
+			it.swaps[j] = it.i
+		}
+		delete(it.swaps, it.i)
+		if x := it.sl.nodeByRank(rank + 1); !x.dead {
+			it.x = x
+		}
+	}
+}
+
+func (it *RandomIterator) Value() Item {
+	return it.sl.out(it.x.item)
+}
+
+// MergeCombineIterator walks the items of several skip lists as one sorted
+// stream, folding the items equal across the lists into one.
+type MergeCombineIterator struct {
+	sl      *SkipList // orders the items
+	lists   []*SkipList
+	combine func(a, b Item) Item
+	heads   []*node
+	value   Item
+}
+
+// NewMergeCombineIterator returns an iterator over the distinct items of the
+// lists, which must share the same order, in ascending order. The items
+// equal to each other, across the lists or within a multiset, are folded
+// through combine in list order and yielded once; each goes through the
+// copier of its list, see SetItemCopier, before combine sees it. Each step
+// scans the head of every list, so it costs O(len(lists)).
+func NewMergeCombineIterator(combine func(a, b Item) Item, lists ...*SkipList) *MergeCombineIterator {
+	it := &MergeCombineIterator{lists: lists, combine: combine, heads: make([]*node, len(lists))}
+	for i, sl := range lists {
+		it.sl = sl
+		it.heads[i] = sl.header.forward[0]
+	}
+	it.Next()
+	return it
+}
+
+func (it *MergeCombineIterator) Valid() bool {
+	return it.value != nil
+}
+
+func (it *MergeCombineIterator) Next() {
+	var min *node
+	for i, x := range it.heads {
+		for x != nil && x.dead {
+			x = x.forward[0]
+		}
+		it.heads[i] = x
+		if x != nil && (min == nil || it.sl.less(x.item, min.item)) {
+			min = x
+		}
+	}
+	it.value = nil
+	if min == nil {
+		return
+	}
+	key := min.item
+	for i, x := range it.heads {
+		for ; x != nil && !it.sl.less(key, x.item); x = x.forward[0] {
+			if x.dead {
+				continue
+			}
+			if it.value == nil {
+				it.value = it.lists[i].out(x.item)
+			} else {
+				it.value = it.combine(it.value, it.lists[i].out(x.item))
+			}
+		}
+		it.heads[i] = x
+	}
+}
+
+func (it *MergeCombineIterator) Value() Item {
+	return it.value
+}
+
+// WindowIterator walks the skip list in ascending order, keeping the last
+// items visited as a sliding window.
+type WindowIterator struct {
+	it     *Iterator
+	window []Item
+}
+
+// NewWindowIterator returns an iterator keeping a window of up to k items.
+func (sl *SkipList) NewWindowIterator(k int) *WindowIterator {
+	if k < 1 {
+		panic("window size must be positive")
+	}
+	w := &WindowIterator{it: sl.NewIterator(), window: make([]Item, 0, k)}
+	w.push()
+	return w
+}
+
+func (w *WindowIterator) Valid() bool {
+	return w.it.Valid()
+}
+
+func (w *WindowIterator) Next() {
+	w.it.Next()
+	w.push()
+}
+
+func (w *WindowIterator) Value() Item {
+	return w.it.Value()
+}
+
+// Window returns the last k items visited, oldest first and ending with the
+// current one. It holds fewer than k items until k items have been visited.
+// The returned slice is overwritten by Next.
+func (w *WindowIterator) Window() []Item {
+	return w.window
+}
+
+func (w *WindowIterator) push() {
+	if !w.it.Valid() {
+		return
+	}
+	if len(w.window) == cap(w.window) {
+		copy(w.window, w.window[1:])
+		w.window = w.window[:len(w.window)-1]
+	}
+	w.window = append(w.window, w.it.Value())
+}
+
+// DistinctIterator walks the skip list in ascending order, visiting only the
+// first of every run of equal items.
+type DistinctIterator struct {
+	sl *SkipList
+	x  *node
+}
+
+func (sl *SkipList) NewDistinctIterator() *DistinctIterator {
+	it := &DistinctIterator{sl: sl, x: sl.header.forward[0]}
+	it.skipDead()
+	return it
+}
+
+func (it *DistinctIterator) Valid() bool {
+	return it.x != nil
+}
+
+// Next moves to the first item greater than the current one.
+func (it *DistinctIterator) Next() {
+	y := it.x.forward[0]
+	for y != nil && !it.sl.less(it.x.item, y.item) {
+		y = y.forward[0]
+	}
+	it.x = y
+	it.skipDead()
+}
+
+// skipDead moves x past soft deleted items, onto the first live item of its
+// key or of a later one.
+func (it *DistinctIterator) skipDead() {
+	for it.x != nil && it.x.dead {
+		it.x = it.x.forward[0]
+	}
+}
+
+func (it *DistinctIterator) Value() Item {
+	return it.sl.out(it.x.item)
+}
+
+// Range holds the items in [begin, end] and a cursor over them that moves
+// both ways. Next past the last item leaves the cursor at End, and Prev
+// before the first one leaves it at Begin; from there, Prev and Next
+// respectively bring it back onto the range.
+type Range struct {
+	sl         *SkipList
+	begin, end *node // first node in the range and the one past it
+	cur        *node
+	before     bool // the cursor is before begin
+}
+
+// Valid reports whether the cursor is on an item of the range.
+func (r *Range) Valid() bool {
+	return !r.before && r.cur != r.end
+}
+
+// Value returns the item under the cursor.
+func (r *Range) Value() Item {
+	return r.sl.out(r.cur.item)
+}
+
+// Begin reports whether the cursor has moved before the first item.
+func (r *Range) Begin() bool {
+	return r.before
+}
+
+// End reports whether the cursor has moved past the last item.
+func (r *Range) End() bool {
+	return !r.before && r.cur == r.end
+}
+
+// Next moves the cursor to the next item.
+func (r *Range) Next() {
+	if r.before {
+		r.before = false
+		r.cur = r.begin
+	} else if r.cur != r.end {
+		r.cur = r.cur.forward[0]
+	}
+	r.skipForward()
+}
+
+// Prev moves the cursor to the previous item.
+func (r *Range) Prev() {
+	for !r.before {
+		switch r.cur {
+		case r.begin:
+			r.before = true
+			return
+		case r.end:
+			if r.end != nil {
+				r.cur = r.end.backward
+			} else {
+				r.cur = r.sl.lastNode()
+			}
+		default:
+			r.cur = r.cur.backward
+		}
+		if !r.cur.dead {
+			return
+		}
+	}
+}
+
+// skipForward moves the cursor past soft deleted items.
+func (r *Range) skipForward() {
+	for r.cur != r.end && r.cur.dead {
+		r.cur = r.cur.forward[0]
+	}
+}
+
+func (r *Range) ForEach(f func(item Item)) {
+	for x := r.begin; x != r.end; x = x.forward[0] {
+		if !x.dead {
+			f(r.sl.out(x.item))
+		}
+	}
+}
+
+// Any wraps a value of any type as an Item, for skip lists created by
+// NewWithComparator. It has no order of its own: its Less panics.
+type Any struct {
+	V interface{}
+}
+
+// Less panics, as only a comparator given to NewWithComparator orders Any
+// items.
+func (a Any) Less(b Item) bool {
+	panic("skiplist: Any items need a comparator, see NewWithComparator")
 }
 
 type Int int
@@ -271,3 +3199,40 @@ type Int int
 func (a Int) Less(b Item) bool {
 	return a < b.(Int)
 }
+
+// ToInts returns the items of a skip list of Int items as an []int, in
+// ascending order. It panics if an item is not an Int.
+func (sl *SkipList) ToInts() []int {
+	ints := make([]int, 0, sl.Len())
+	for it := sl.NewIterator(); it.Valid(); it.Next() {
+		v, ok := it.Value().(Int)
+		if !ok {
+			panic(fmt.Sprintf("skiplist: ToInts on a non-Int item %v of type %T", it.Value(), it.Value()))
+		}
+		ints = append(ints, int(v))
+	}
+	return ints
+}
+
+// CIString is a string item ordered case-insensitively, so "Alice" and
+// "alice" are equal. Create one with NewCIString, which folds the string
+// once rather than on every comparison.
+type CIString struct {
+	s      string
+	folded string
+}
+
+// NewCIString returns s as a CIString.
+func NewCIString(s string) CIString {
+	return CIString{s: s, folded: strings.ToLower(s)}
+}
+
+// String returns the string as given to NewCIString.
+func (a CIString) String() string {
+	return a.s
+}
+
+// Less compares the lower case forms of a and b.
+func (a CIString) Less(b Item) bool {
+	return a.folded < b.(CIString).folded
+}
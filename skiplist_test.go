@@ -1,9 +1,14 @@
 package skiplist
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"math/rand"
 	"reflect"
+	"runtime"
+	"sync"
 	"testing"
 	"time"
 )
@@ -114,6 +119,284 @@ func TestIterator(t *testing.T) {
 	}
 }
 
+func TestIteratorInvalidation(t *testing.T) {
+	mustPanic := func(name string, f func()) {
+		t.Helper()
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("%s on an invalidated iterator should panic", name)
+			}
+		}()
+		f()
+	}
+
+	sl := New()
+	for _, v := range perm(10) {
+		sl.Insert(v)
+	}
+	it := sl.NewIterator()
+	it.MoveTo(Int(5))
+	sl.Insert(Int(100))
+	if it.Value() != Int(5) {
+		t.Fatalf("insert should not invalidate the iterator, got %v", it.Value())
+	}
+
+	sl.Delete(Int(5))
+	mustPanic("Value", func() { it.Value() })
+	mustPanic("Next", func() { it.Next() })
+
+	// A recycled node must not be mistaken for the deleted one.
+	sl.Insert(Int(5))
+	mustPanic("Value", func() { it.Value() })
+
+	it.MoveTo(Int(5))
+	if !it.Valid() || it.Value() != Int(5) {
+		t.Fatal("MoveTo should revalidate the iterator")
+	}
+	it.Next()
+	if it.Value() != Int(6) {
+		t.Fatalf("want 6, got %v", it.Value())
+	}
+}
+
+func TestDifferenceIterator(t *testing.T) {
+	a, b := New(), New()
+	for _, v := range perm(10) {
+		a.Insert(v)
+	}
+	for _, v := range []Int{2, 4, 6} {
+		b.Insert(v)
+	}
+
+	var got []Item
+	for it := NewDifferenceIterator(a, b); it.Valid(); it.Next() {
+		got = append(got, it.Value())
+	}
+	want := []Item{Int(0), Int(1), Int(3), Int(5), Int(7), Int(8), Int(9)}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	if NewDifferenceIterator(b, a).Valid() {
+		t.Fatal("b is a subset of a, difference should be empty")
+	}
+	if NewDifferenceIterator(New(), a).Valid() {
+		t.Fatal("difference of an empty list should be empty")
+	}
+}
+
+func TestNewIteratorSince(t *testing.T) {
+	sl := NewVersioned()
+	for i := Int(0); i < 10; i += 2 {
+		sl.Insert(i)
+	}
+	since := sl.Version()
+	for i := Int(1); i < 10; i += 2 {
+		sl.Insert(i)
+	}
+	sl.Insert(Int(4))
+
+	var got []Item
+	for it := sl.NewIteratorSince(since); it.Valid(); it.Next() {
+		got = append(got, it.Value())
+	}
+	want := []Item{Int(1), Int(3), Int(4), Int(5), Int(7), Int(9)}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	it := sl.NewIteratorSince(sl.Version())
+	if it.Valid() {
+		t.Fatalf("nothing changed since the current version, got %v", it.Value())
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old, cur := New(), New()
+	for i := Int(0); i < 10; i++ {
+		old.Insert(i)
+		cur.Insert(i + 2)
+	}
+	added, removed := cur.Diff(old)
+	if want := []Item{Int(10), Int(11)}; !reflect.DeepEqual(added, want) {
+		t.Fatalf("added: got %v, want %v", added, want)
+	}
+	if want := []Item{Int(0), Int(1)}; !reflect.DeepEqual(removed, want) {
+		t.Fatalf("removed: got %v, want %v", removed, want)
+	}
+
+	added, removed = cur.Diff(cur)
+	if len(added) != 0 || len(removed) != 0 {
+		t.Fatalf("self diff: got %v, %v", added, removed)
+	}
+	added, removed = New().Diff(old)
+	if len(added) != 0 || !reflect.DeepEqual(removed, rang(10)) {
+		t.Fatalf("diff of empty list: got %v, %v", added, removed)
+	}
+	cur.SoftDelete(Int(11))
+	cur.SoftDelete(Int(5))
+	old.SoftDelete(Int(0))
+	added, removed = cur.Diff(old)
+	if want := []Item{Int(10)}; !reflect.DeepEqual(added, want) {
+		t.Fatalf("soft deleted, added: got %v, want %v", added, want)
+	}
+	if want := []Item{Int(1), Int(5)}; !reflect.DeepEqual(removed, want) {
+		t.Fatalf("soft deleted, removed: got %v, want %v", removed, want)
+	}
+}
+
+func TestMultiset(t *testing.T) {
+	sl := NewMultiset()
+	for i := 0; i < 3; i++ {
+		for _, v := range perm(10) {
+			sl.Insert(v)
+		}
+	}
+	if sl.Len() != 30 {
+		t.Fatalf("len: want 30, got %d", sl.Len())
+	}
+	checkSpans(t, sl)
+	if !sl.IsSorted() {
+		t.Fatal("multiset should be sorted")
+	}
+	for _, v := range perm(10) {
+		if !sl.Delete(v) {
+			t.Fatalf("didn't delete %v", v)
+		}
+	}
+	if sl.Len() != 20 || sl.Search(Int(3)) != Int(3) {
+		t.Fatal("delete should remove a single copy")
+	}
+	checkSpans(t, sl)
+
+	// Equal items keep their insertion order.
+	sl = NewMultiset()
+	for i := 0; i < 5; i++ {
+		sl.Insert(kv{key: i % 2, value: i})
+	}
+	var got []Item
+	for it := sl.NewIterator(); it.Valid(); it.Next() {
+		got = append(got, it.Value())
+	}
+	want := []Item{kv{0, 0}, kv{0, 2}, kv{0, 4}, kv{1, 1}, kv{1, 3}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestMultisetFIFO(t *testing.T) {
+	sl := NewMultiset()
+	queues := make(map[int][]kv)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		key := r.Intn(10)
+		if r.Intn(3) == 0 && len(queues[key]) > 0 {
+			if first := sl.Search(kv{key: key}); first != queues[key][0] {
+				t.Fatalf("key %d: want %v first, got %v", key, queues[key][0], first)
+			}
+			sl.Delete(kv{key: key})
+			queues[key] = queues[key][1:]
+			continue
+		}
+		e := kv{key, i}
+		sl.Insert(e)
+		queues[key] = append(queues[key], e)
+	}
+	for key := 0; key < 10; key++ {
+		got := sl.SearchAll(kv{key: key})
+		if len(got) != len(queues[key]) {
+			t.Fatalf("key %d: want %d items, got %d", key, len(queues[key]), len(got))
+		}
+		for i, item := range got {
+			if item != queues[key][i] {
+				t.Fatalf("key %d item %d: want %v, got %v", key, i, queues[key][i], item)
+			}
+		}
+	}
+}
+
+func TestDistinctIterator(t *testing.T) {
+	sl := NewMultiset()
+	for _, v := range []Int{3, 1, 2, 3, 1} {
+		sl.Insert(v)
+	}
+	var got []Item
+	for it := sl.NewDistinctIterator(); it.Valid(); it.Next() {
+		got = append(got, it.Value())
+	}
+	if want := []Item{Int(1), Int(2), Int(3)}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if New().NewDistinctIterator().Valid() {
+		t.Fatal("iterator over an empty list should be invalid")
+	}
+}
+
+func TestMultisetSoftDelete(t *testing.T) {
+	sl := NewMultiset()
+	a, b, c := kv{1, 0}, kv{1, 1}, kv{1, 2}
+	for _, v := range []kv{a, b, c, {0, 0}, {2, 0}} {
+		sl.Insert(v)
+	}
+	if !sl.SoftDelete(kv{key: 1}) {
+		t.Fatal("first soft delete missed")
+	}
+	if got := sl.Search(kv{key: 1}); got != b {
+		t.Fatalf("search: want %v, got %v", b, got)
+	}
+	if !sl.SoftDelete(kv{key: 1}) {
+		t.Fatal("second soft delete missed")
+	}
+	if got := sl.SearchAll(kv{key: 1}); !reflect.DeepEqual(got, []Item{c}) {
+		t.Fatalf("search all: want [%v], got %v", c, got)
+	}
+	var got []Item
+	for it := sl.NewDistinctIterator(); it.Valid(); it.Next() {
+		got = append(got, it.Value())
+	}
+	if want := []Item{kv{0, 0}, c, kv{2, 0}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("distinct: got %v, want %v", got, want)
+	}
+	if !sl.Delete(kv{key: 1}) {
+		t.Fatal("delete missed the live item")
+	}
+	if sl.Search(kv{key: 1}) != nil || sl.Len() != 2 {
+		t.Fatalf("after delete: want no key 1 and len 2, got %v and %d", sl.Search(kv{key: 1}), sl.Len())
+	}
+	if err := sl.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	sl.SoftDelete(kv{key: 0})
+	if it := sl.NewDistinctIterator(); !it.Valid() || it.Value() != (kv{2, 0}) {
+		t.Fatal("distinct iterator should start past a soft deleted first item")
+	}
+}
+
+func TestWindowIterator(t *testing.T) {
+	sl := New()
+	for _, v := range perm(10) {
+		sl.Insert(v)
+	}
+	i := 0
+	for it := sl.NewWindowIterator(3); it.Valid(); it.Next() {
+		from := i - 2
+		if from < 0 {
+			from = 0
+		}
+		if got, want := it.Window(), rang(i + 1)[from:]; !reflect.DeepEqual(got, want) {
+			t.Fatalf("step %d: got %v, want %v", i, got, want)
+		}
+		if it.Value() != Int(i) {
+			t.Fatalf("step %d: value %v", i, it.Value())
+		}
+		i++
+	}
+	if i != 10 {
+		t.Fatalf("want 10 steps, got %d", i)
+	}
+}
+
 func TestRange(t *testing.T) {
 	sl := New()
 	{
@@ -173,84 +456,2894 @@ func TestRange(t *testing.T) {
 	}
 }
 
-const benchmarkListSize = 10000
+func TestWalkLevel(t *testing.T) {
+	sl := New()
+	sl.random = rand.New(rand.NewSource(1))
+	for _, v := range perm(1000) {
+		sl.Insert(v)
+	}
+	if sl.level < 2 {
+		t.Fatalf("level: want >= 2, got %d", sl.level)
+	}
 
-func BenchmarkInsert(b *testing.B) {
-	b.StopTimer()
-	insertP := perm(benchmarkListSize)
-	b.StartTimer()
-	i := 0
-	for i < b.N {
-		sl := New()
-		for _, item := range insertP {
-			sl.Insert(item)
-			i++
-			if i >= b.N {
-				return
-			}
+	var want []Item
+	for x := sl.header.forward[0]; x != nil; x = x.forward[0] {
+		if len(x.forward) >= 2 {
+			want = append(want, x.item)
 		}
 	}
+	var got []Item
+	sl.WalkLevel(1, func(item Item) {
+		got = append(got, item)
+	})
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
 }
 
-func BenchmarkSearch(b *testing.B) {
-	b.StopTimer()
-	insertP := perm(benchmarkListSize)
-	searchP := perm(benchmarkListSize)
-	b.StartTimer()
-	i := 0
-	for i < b.N {
-		b.StopTimer()
-		tr := New()
-		for _, v := range insertP {
-			tr.Insert(v)
+func TestApproxRank(t *testing.T) {
+	const listSize = 10000
+	sl := New()
+	sl.random = rand.New(rand.NewSource(1))
+	for _, v := range rang(listSize) {
+		sl.Insert(v)
+	}
+
+	var total int
+	for i := 0; i < listSize; i++ {
+		got := sl.ApproxRank(Int(i))
+		if got < 0 || got > listSize {
+			t.Fatalf("ApproxRank(%d) = %d out of range", i, got)
 		}
-		b.StartTimer()
-		for _, item := range searchP {
-			tr.Search(item)
-			i++
-			if i >= b.N {
-				return
+		if d := got - (i + 1); d < 0 {
+			total -= d
+		} else {
+			total += d
+		}
+	}
+	if mean := total / listSize; mean > listSize/4 {
+		t.Fatalf("mean error %d exceeds %d", mean, listSize/4)
+	}
+	if got := New().ApproxRank(Int(1)); got != 0 {
+		t.Fatalf("empty list: want 0, got %d", got)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	sl := New()
+	for _, v := range perm(10) {
+		sl.Insert(v)
+	}
+
+	var items []Item
+	for i := 100; i < 110; i++ {
+		items = append(items, Int(i))
+	}
+	sl.Replace(items)
+	if sl.Len() != len(items) {
+		t.Fatalf("len: want %d, got %d", len(items), sl.Len())
+	}
+	var got []Item
+	for it := sl.NewIterator(); it.Valid(); it.Next() {
+		got = append(got, it.Value())
+	}
+	if !reflect.DeepEqual(got, items) {
+		t.Fatalf("got %v, want %v", got, items)
+	}
+	if sl.Search(Int(5)) != nil || sl.Search(Int(105)) == nil {
+		t.Fatal("search mismatch after replace")
+	}
+
+	sl.Replace(nil)
+	if sl.Len() != 0 || sl.NewIterator().Valid() {
+		t.Fatal("replace with no items should empty the list")
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("unsorted items should panic")
 			}
+		}()
+		sl.Replace([]Item{Int(2), Int(1)})
+	}()
+}
+
+func TestPrewarmFreeList(t *testing.T) {
+	const n = 1000
+	sl := NewWithLevel(16)
+	sl.PrewarmFreeList(2*n, 16)
+
+	// Box the items beforehand so only the inserts are measured.
+	items := perm(2 * n)
+	next := 0
+	allocs := testing.AllocsPerRun(1, func() {
+		for _, item := range items[next : next+n] {
+			sl.Insert(item)
 		}
+		next += n
+	})
+	if allocs != 0 {
+		t.Fatalf("want 0 allocs, got %v", allocs)
+	}
+	if sl.Len() != 2*n {
+		t.Fatalf("len: want %d, got %d", 2*n, sl.Len())
 	}
 }
 
-func BenchmarkDeleteInsert(b *testing.B) {
-	b.StopTimer()
-	insertP := perm(benchmarkListSize)
+func TestGetRange(t *testing.T) {
 	sl := New()
-	for _, item := range insertP {
-		sl.Insert(item)
+	if got := sl.GetRange(Int(2), Int(5)); len(got) != 0 {
+		t.Fatalf("empty list: got %v", got)
 	}
-	b.StartTimer()
-	for i := 0; i < b.N; i++ {
-		sl.Delete(insertP[i%benchmarkListSize])
-		sl.Insert(insertP[i%benchmarkListSize])
+	for _, v := range perm(10) {
+		sl.Insert(v)
+	}
+
+	if got, want := sl.GetRange(Int(2), Int(5)), []Item{Int(2), Int(3), Int(4), Int(5)}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := sl.GetRangeReverse(Int(2), Int(5)), []Item{Int(5), Int(4), Int(3), Int(2)}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got := sl.GetRangeReverse(Int(5), Int(2)); len(got) != 0 {
+		t.Fatalf("reversed bounds: got %v", got)
+	}
+	if got := sl.GetRangeReverse(Int(20), Int(30)); len(got) != 0 {
+		t.Fatalf("out of range: got %v", got)
 	}
 }
 
-func BenchmarkDelete(b *testing.B) {
-	b.StopTimer()
-	insertP := perm(benchmarkListSize)
-	removeP := perm(benchmarkListSize)
-	b.StartTimer()
-	i := 0
-	for i < b.N {
-		b.StopTimer()
-		sl := New()
-		for _, v := range insertP {
-			sl.Insert(v)
+func TestSetLevelFunc(t *testing.T) {
+	const maxLevel = 8
+	sl := NewWithLevel(maxLevel)
+	sl.SetLevelFunc(func() int32 { return 100 })
+	for _, v := range perm(100) {
+		sl.Insert(v)
+	}
+	if sl.level != maxLevel {
+		t.Fatalf("level: want %d, got %d", maxLevel, sl.level)
+	}
+	for x := sl.header.forward[0]; x != nil; x = x.forward[0] {
+		if len(x.forward) != maxLevel {
+			t.Fatalf("node %v: want height %d, got %d", x.item, maxLevel, len(x.forward))
 		}
-		b.StartTimer()
-		for _, item := range removeP {
-			sl.Delete(item)
-			i++
-			if i >= b.N {
-				return
+	}
+	for _, v := range perm(100) {
+		if sl.Search(v) != v {
+			t.Fatalf("didn't find %v", v)
+		}
+	}
+
+	sl.SetLevelFunc(func() int32 { return -1 })
+	sl.Insert(Int(100))
+	if x := sl.searchNode(Int(100)); len(x.forward) != 1 {
+		t.Fatalf("want height 1, got %d", len(x.forward))
+	}
+}
+
+// checkSpans verifies the span of every forward pointer in sl.
+func checkSpans(t *testing.T, sl *SkipList) {
+	t.Helper()
+	rank := map[*node]int{sl.header: 0}
+	n := 0
+	for x := sl.header.forward[0]; x != nil; x = x.forward[0] {
+		n++
+		rank[x] = n
+	}
+	if n != sl.Len() {
+		t.Fatalf("len: want %d, got %d", n, sl.Len())
+	}
+	var prev *node
+	for x := sl.header.forward[0]; x != nil; prev, x = x, x.forward[0] {
+		if x.backward != prev {
+			t.Fatalf("backward link of %v is wrong", x.item)
+		}
+	}
+	for x := range rank {
+		for i := int32(0); i < int32(len(x.forward)) && i < sl.level; i++ {
+			want := n - rank[x]
+			if y := x.forward[i]; y != nil {
+				want = rank[y] - rank[x]
+			}
+			if x.span[i] != want {
+				t.Fatalf("span of %v at level %d: want %d, got %d", x.item, i, want, x.span[i])
 			}
 		}
-		if sl.Len() > 0 {
-			panic(sl.Len())
+	}
+}
+
+func TestCountLessGreater(t *testing.T) {
+	sl := New()
+	for _, v := range perm(100) {
+		sl.Insert(v)
+	}
+	checkSpans(t, sl)
+	if got := sl.CountLess(Int(50)); got != 50 {
+		t.Fatalf("CountLess: want 50, got %d", got)
+	}
+	if got := sl.CountGreater(Int(50)); got != 49 {
+		t.Fatalf("CountGreater: want 49, got %d", got)
+	}
+	if got := sl.CountLess(Int(-1)) + sl.CountGreater(Int(-1)); got != 100 {
+		t.Fatalf("absent key: want 100, got %d", got)
+	}
+
+	for _, v := range perm(100)[:50] {
+		sl.Delete(v)
+	}
+	checkSpans(t, sl)
+	for _, v := range perm(200) {
+		sl.Insert(v)
+	}
+	checkSpans(t, sl)
+	for i := 0; i < 200; i++ {
+		if got := sl.CountLess(Int(i)); got != i {
+			t.Fatalf("CountLess(%d): got %d", i, got)
+		}
+		if got := sl.CountGreater(Int(i)); got != 199-i {
+			t.Fatalf("CountGreater(%d): got %d", i, got)
 		}
 	}
+
+	sl.Replace(rang(30))
+	checkSpans(t, sl)
+	for _, v := range perm(40) {
+		sl.Insert(v)
+	}
+	checkSpans(t, sl)
+	if got := sl.CountLess(Int(10)); got != 10 {
+		t.Fatalf("CountLess after Replace: want 10, got %d", got)
+	}
+}
+
+func TestStats(t *testing.T) {
+	sl := New()
+	for i := Int(0); i < 10; i++ {
+		sl.Insert(i)
+	}
+	sl.Insert(Int(5))
+	sl.Delete(Int(3))
+	sl.Delete(Int(3))
+
+	want := Stats{Inserts: 10, Overwrites: 1, Deletes: 1, DeleteMisses: 1}
+	if got := sl.Stats(); got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	// every other way of removing items counts as deletes too
+	sl.PopLE(Int(0))          // 0
+	sl.PopMinN(2)             // 1, 2
+	sl.Retain(Int(5), Int(8)) // 4, 9
+	sl.SoftDelete(Int(6))
+	sl.Compact()                     // 6
+	sl.InsertWindow(Int(10), Int(7)) // 5
+	want.Inserts++
+	want.Deletes += 7
+	if got := sl.Stats(); got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestMedian(t *testing.T) {
+	sl := New()
+	if got := sl.Median(); got != nil {
+		t.Fatalf("empty list: want nil, got %v", got)
+	}
+	for _, v := range perm(9) {
+		sl.Insert(v)
+	}
+	if got := sl.Median(); got != Int(4) {
+		t.Fatalf("odd length: want 4, got %v", got)
+	}
+	sl.Insert(Int(9))
+	if got := sl.Median(); got != Int(4) {
+		t.Fatalf("even length: want 4, got %v", got)
+	}
+	for i := 1; i <= sl.Len(); i++ {
+		if got := sl.nodeByRank(i).item; got != Int(i-1) {
+			t.Fatalf("rank %d: want %d, got %v", i, i-1, got)
+		}
+	}
+}
+
+func TestSearchBudget(t *testing.T) {
+	sl := New()
+	for _, v := range perm(1000) {
+		sl.Insert(v)
+	}
+
+	if item, found, exhausted := sl.SearchBudget(Int(999), 1); item != nil || found || !exhausted {
+		t.Fatalf("tiny budget: got (%v, %v, %v)", item, found, exhausted)
+	}
+	if item, found, exhausted := sl.SearchBudget(Int(999), 1000); item != Int(999) || !found || exhausted {
+		t.Fatalf("generous budget: got (%v, %v, %v)", item, found, exhausted)
+	}
+	if item, found, exhausted := sl.SearchBudget(Int(1000), 1000); item != nil || found || exhausted {
+		t.Fatalf("absent key: got (%v, %v, %v)", item, found, exhausted)
+	}
+}
+
+func TestAdaptive(t *testing.T) {
+	sl := NewAdaptive()
+	if sl.maxLevel != adaptiveMinLevel {
+		t.Fatalf("maxLevel: want %d, got %d", adaptiveMinLevel, sl.maxLevel)
+	}
+
+	const listSize = 1000000
+	for _, v := range rang(listSize) {
+		sl.Insert(v)
+	}
+	// 4^9 < listSize <= 4^10
+	if sl.maxLevel != 10 {
+		t.Fatalf("maxLevel: want 10, got %d", sl.maxLevel)
+	}
+	if sl.level < 8 {
+		t.Fatalf("level: want about 10, got %d", sl.level)
+	}
+	for _, v := range perm(1000) {
+		if sl.Search(v) != v {
+			t.Fatalf("didn't find %v", v)
+		}
+	}
+
+	sl = NewAdaptive()
+	sl.Replace(rang(1000))
+	if sl.maxLevel != 5 {
+		t.Fatalf("maxLevel after Replace: want 5, got %d", sl.maxLevel)
+	}
+	checkSpans(t, sl)
+}
+
+func TestPopLE(t *testing.T) {
+	sl := New()
+	for _, v := range perm(10) {
+		sl.Insert(v)
+	}
+	for want := Int(5); want >= 0; want-- {
+		item, ok := sl.PopLE(Int(5))
+		if !ok || item != want {
+			t.Fatalf("want (%v, true), got (%v, %v)", want, item, ok)
+		}
+		checkSpans(t, sl)
+	}
+	if item, ok := sl.PopLE(Int(5)); ok {
+		t.Fatalf("want false, got %v", item)
+	}
+	if sl.Len() != 4 {
+		t.Fatalf("len: want 4, got %d", sl.Len())
+	}
+	if item, ok := sl.PopLE(Int(100)); !ok || item != Int(9) {
+		t.Fatalf("want (9, true), got (%v, %v)", item, ok)
+	}
+}
+
+func TestNewWithRand(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	a, b := NewWithRand(r), NewWithRand(r)
+	for _, v := range perm(100) {
+		a.Insert(v)
+		b.Insert(v)
+	}
+	for _, v := range perm(100) {
+		if a.Search(v) != v || b.Search(v) != v {
+			t.Fatalf("didn't find %v", v)
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+	sl := New()
+	for _, v := range perm(10) {
+		sl.Insert(v)
+	}
+	check := func(key Item, prev, cur, next Item, found bool) {
+		t.Helper()
+		p, c, n, f := sl.Context(key)
+		if p != prev || c != cur || n != next || f != found {
+			t.Fatalf("Context(%v): got (%v, %v, %v, %v), want (%v, %v, %v, %v)",
+				key, p, c, n, f, prev, cur, next, found)
+		}
+	}
+	check(Int(5), Int(4), Int(5), Int(6), true)
+	check(Int(0), nil, Int(0), Int(1), true)
+	check(Int(9), Int(8), Int(9), nil, true)
+	check(Int(-1), nil, nil, Int(0), false)
+	check(Int(10), Int(9), nil, nil, false)
+
+	sl.Delete(Int(5))
+	check(Int(5), Int(4), nil, Int(6), false)
+
+	sl.SoftDelete(Int(3))
+	sl.SoftDelete(Int(6))
+	check(Int(3), Int(2), nil, Int(4), false)
+	check(Int(4), Int(2), Int(4), Int(7), true)
+	sl.SoftDelete(Int(0))
+	check(Int(1), nil, Int(1), Int(2), true)
+
+	ms := NewMultiset()
+	for _, v := range []kv{{0, 0}, {1, 0}, {1, 1}, {2, 0}} {
+		ms.Insert(v)
+	}
+	ms.SoftDelete(kv{key: 1})
+	if p, c, n, f := ms.Context(kv{key: 1}); p != (kv{0, 0}) || c != (kv{1, 1}) || n != (kv{2, 0}) || !f {
+		t.Fatalf("multiset: got (%v, %v, %v, %v)", p, c, n, f)
+	}
+	ms.SoftDelete(kv{key: 1})
+	if p, c, n, f := ms.Context(kv{key: 1}); p != (kv{0, 0}) || c != nil || n != (kv{2, 0}) || f {
+		t.Fatalf("multiset, all soft deleted: got (%v, %v, %v, %v)", p, c, n, f)
+	}
+}
+
+func TestRetain(t *testing.T) {
+	sl := New()
+	for _, v := range perm(100) {
+		sl.Insert(v)
+	}
+	if n := sl.Retain(Int(30), Int(60)); n != 69 {
+		t.Fatalf("removed: want 69, got %d", n)
+	}
+	if sl.Len() != 31 {
+		t.Fatalf("len: want 31, got %d", sl.Len())
+	}
+	checkSpans(t, sl)
+	var got []Item
+	for it := sl.NewIterator(); it.Valid(); it.Next() {
+		got = append(got, it.Value())
+	}
+	if want := rang(61)[30:]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	if n := sl.Retain(Int(0), Int(100)); n != 0 {
+		t.Fatalf("nothing to remove, got %d", n)
+	}
+	for _, v := range perm(100) {
+		sl.Insert(v)
+	}
+	checkSpans(t, sl)
+	if n := sl.Retain(Int(60), Int(30)); n != 100 || sl.Len() != 0 {
+		t.Fatalf("empty window: removed %d, len %d", n, sl.Len())
+	}
+	checkSpans(t, sl)
+}
+
+func TestSoftDelete(t *testing.T) {
+	sl := New()
+	for _, v := range perm(10) {
+		sl.Insert(v)
+	}
+	for _, v := range []Int{2, 5, 9} {
+		if !sl.SoftDelete(v) {
+			t.Fatalf("didn't find %v", v)
+		}
+	}
+	if sl.SoftDelete(Int(5)) || sl.SoftDelete(Int(10)) {
+		t.Fatal("soft deleting an absent item should fail")
+	}
+	if sl.Len() != 7 {
+		t.Fatalf("len: want 7, got %d", sl.Len())
+	}
+	if sl.Search(Int(5)) != nil {
+		t.Fatal("soft deleted item should not be found")
+	}
+	want := []Item{Int(0), Int(1), Int(3), Int(4), Int(6), Int(7), Int(8)}
+	var got []Item
+	for it := sl.NewIterator(); it.Valid(); it.Next() {
+		got = append(got, it.Value())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("iterator: got %v, want %v", got, want)
+	}
+	if got := sl.GetRange(Int(0), Int(9)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("range: got %v, want %v", got, want)
+	}
+
+	sl.Insert(Int(2))
+	if sl.Len() != 8 || sl.Search(Int(2)) != Int(2) {
+		t.Fatal("insert should revive a soft deleted item")
+	}
+	if sl.Delete(Int(9)) {
+		t.Fatal("deleting a soft deleted item should fail")
+	}
+	if sl.Len() != 8 {
+		t.Fatalf("len: want 8, got %d", sl.Len())
+	}
+
+	if n := sl.Compact(); n != 1 {
+		t.Fatalf("compact: want 1, got %d", n)
+	}
+	if sl.Len() != 8 || sl.length != 8 {
+		t.Fatalf("len: want 8, got %d (%d linked)", sl.Len(), sl.length)
+	}
+	checkSpans(t, sl)
+	if n := sl.Compact(); n != 0 {
+		t.Fatalf("compact: want 0, got %d", n)
+	}
+
+	for _, v := range perm(10) {
+		sl.SoftDelete(v)
+	}
+	if sl.Len() != 0 || sl.NewIterator().Valid() {
+		t.Fatal("all items are soft deleted")
+	}
+	if n := sl.Compact(); n != 8 || sl.length != 0 {
+		t.Fatalf("compact: want 8, got %d (%d linked)", n, sl.length)
+	}
+	checkSpans(t, sl)
+}
+
+func TestSoftDeletePositions(t *testing.T) {
+	sl := New()
+	for _, v := range perm(10) {
+		sl.Insert(v)
+	}
+	sl.SoftDelete(Int(4))
+	sl.SoftDelete(Int(5))
+	if got := sl.Median(); got != Int(3) {
+		t.Fatalf("median: want 3, got %v", got)
+	}
+	if got := sl.GetByRank(5); got != Int(6) {
+		t.Fatalf("rank 5: want 6, got %v", got)
+	}
+	if got := sl.GetByRank(9); got != nil {
+		t.Fatalf("rank 9: want nil, got %v", got)
+	}
+	if got := sl.AtFraction(1); got != Int(9) {
+		t.Fatalf("fraction 1: want 9, got %v", got)
+	}
+	if got, want := sl.RangeByRank(3, 6), []Item{Int(2), Int(3), Int(6), Int(7)}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("range by rank: got %v, want %v", got, want)
+	}
+	if got, ok := sl.NthGreater(Int(3), 1); !ok || got != Int(6) {
+		t.Fatalf("successor of 3: want (6, true), got (%v, %v)", got, ok)
+	}
+	if first, last, n := sl.RangeBounds(Int(3), Int(6)); first != Int(3) || last != Int(6) || n != 2 {
+		t.Fatalf("bounds: want (3, 6, 2), got (%v, %v, %d)", first, last, n)
+	}
+	if first, _, n := sl.RangeBounds(Int(4), Int(5)); first != nil || n != 0 {
+		t.Fatalf("bounds: want (nil, nil, 0), got (%v, _, %d)", first, n)
+	}
+
+	if item, ok := sl.PopLE(Int(5)); !ok || item != Int(3) {
+		t.Fatalf("pop: want (3, true), got (%v, %v)", item, ok)
+	}
+	if sl.Len() != 7 {
+		t.Fatalf("len: want 7, got %d", sl.Len())
+	}
+	if err := sl.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []Int{0, 1, 2} {
+		sl.SoftDelete(v)
+	}
+	if item, ok := sl.PopLE(Int(5)); ok {
+		t.Fatalf("pop: want false, got %v", item)
+	}
+	if err := sl.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestToInts(t *testing.T) {
+	sl := New()
+	if got := sl.ToInts(); len(got) != 0 {
+		t.Fatalf("empty list: got %v", got)
+	}
+	for _, v := range perm(10) {
+		sl.Insert(v)
+	}
+	if got, want := sl.ToInts(), []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("non-Int items should panic")
+		}
+	}()
+	sl = New()
+	sl.Insert(kv{key: 1})
+	sl.ToInts()
+}
+
+func TestNilKeys(t *testing.T) {
+	sl := New()
+	for _, v := range perm(10) {
+		sl.Insert(v)
+	}
+	for name, f := range map[string]func(){
+		"SearchAll":        func() { sl.SearchAll(nil) },
+		"CountEqual":       func() { sl.CountEqual(nil) },
+		"SearchBudget":     func() { sl.SearchBudget(nil, 10) },
+		"Context":          func() { sl.Context(nil) },
+		"SearchClosest":    func() { sl.SearchClosest(nil, Floor) },
+		"ApproxRank":       func() { sl.ApproxRank(nil) },
+		"ApproxCountRange": func() { sl.ApproxCountRange(Int(0), nil) },
+		"CountLess":        func() { sl.CountLess(nil) },
+		"CountGreater":     func() { sl.CountGreater(nil) },
+		"RankRangeOf":      func() { sl.RankRangeOf(nil) },
+		"SearchIndex":      func() { sl.SearchIndex(nil) },
+		"NthGreater":       func() { sl.NthGreater(nil, 1) },
+		"Rank":             func() { sl.Rank(nil) },
+		"InsertMerge":      func() { sl.InsertMerge(nil, nil) },
+		"InsertIf":         func() { sl.InsertIf(nil, nil) },
+		"DeleteAndNext":    func() { sl.DeleteAndNext(nil) },
+		"DeleteCompact":    func() { sl.DeleteCompact(nil) },
+		"SoftDelete":       func() { sl.SoftDelete(nil) },
+		"PopLE":            func() { sl.PopLE(nil) },
+		"InsertWindow":     func() { sl.InsertWindow(Int(20), nil) },
+		"Retain":           func() { sl.Retain(nil, Int(5)) },
+		"Pin":              func() { sl.Pin(nil) },
+		"Unpin":            func() { sl.Unpin(nil) },
+		"IterateUntil":     func() { sl.IterateUntil(nil, func(Item) bool { return true }) },
+		"NewRange":         func() { sl.NewRange(nil, Int(5)) },
+		"NewRangeSorted":   func() { sl.NewRangeSorted(Int(5), nil) },
+		"GetRange":         func() { sl.GetRange(Int(0), nil) },
+		"GetRangeReverse":  func() { sl.GetRangeReverse(nil, Int(5)) },
+		"RangeBounds":      func() { sl.RangeBounds(nil, Int(5)) },
+		"CountRangeFunc":   func() { sl.CountRangeFunc(nil, Int(5), func(Item) bool { return true }) },
+		"Iterator.MoveTo":  func() { sl.NewIterator().MoveTo(nil) },
+		"PrefixSum":        func() { NewWeighted(func(Item) float64 { return 1 }).PrefixSum(nil) },
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r != ErrNilItem {
+					t.Errorf("%s: want panic with %v, got %v", name, ErrNilItem, r)
+				}
+			}()
+			f()
+		}()
+	}
+}
+
+func TestInvalidItems(t *testing.T) {
+	mustPanic := func(name string, want error, f func()) {
+		t.Helper()
+		defer func() {
+			r := recover()
+			if r == nil {
+				t.Fatalf("%s should panic", name)
+			}
+			if err, ok := r.(error); want != nil && (!ok || !errors.Is(err, want)) {
+				t.Fatalf("%s: want panic with %v, got %v", name, want, r)
+			}
+		}()
+		f()
+	}
+
+	sl := New()
+	mustPanic("Insert(nil)", ErrNilItem, func() { sl.Insert(nil) })
+	mustPanic("Search(nil)", ErrNilItem, func() { sl.Search(nil) })
+	mustPanic("Delete(nil)", ErrNilItem, func() { sl.Delete(nil) })
+	if err := sl.TryInsertItem(nil); err != ErrNilItem {
+		t.Fatalf("TryInsertItem(nil): want %v, got %v", ErrNilItem, err)
+	}
+
+	for _, v := range perm(10) {
+		if err := sl.TryInsertItem(v); err != nil {
+			t.Fatalf("TryInsertItem(%v): %v", v, err)
+		}
+	}
+	mustPanic("Insert(kv)", nil, func() { sl.Insert(kv{key: 1}) })
+	if err := sl.TryInsertItem(kv{key: 1}); !errors.Is(err, ErrIncomparable) {
+		t.Fatalf("TryInsertItem(kv): want %v, got %v", ErrIncomparable, err)
+	}
+	if sl.Len() != 10 {
+		t.Fatalf("len: want 10, got %d", sl.Len())
+	}
+	checkSpans(t, sl)
+}
+
+func TestArena(t *testing.T) {
+	sl := NewWithArena()
+	for _, v := range perm(5000) {
+		sl.Insert(v)
+	}
+	for _, v := range perm(5000)[:2500] {
+		sl.Delete(v)
+	}
+	for _, v := range perm(5000) {
+		sl.Insert(v)
+	}
+	if sl.Len() != 5000 {
+		t.Fatalf("len: want 5000, got %d", sl.Len())
+	}
+	checkSpans(t, sl)
+	for i, x := 0, sl.header.forward[0]; x != nil; i, x = i+1, x.forward[0] {
+		if x.item != Int(i) {
+			t.Fatalf("want %d, got %v", i, x.item)
+		}
+	}
+}
+
+func TestSetMaxNodeLevel(t *testing.T) {
+	sl := New()
+	sl.SetMaxNodeLevel(4)
+	for _, v := range perm(10000) {
+		sl.Insert(v)
+	}
+	if sl.level > 4 {
+		t.Fatalf("level: want <= 4, got %d", sl.level)
+	}
+	for x := sl.header.forward[0]; x != nil; x = x.forward[0] {
+		if len(x.forward) > 4 {
+			t.Fatalf("node %v: height %d exceeds 4", x.item, len(x.forward))
+		}
+	}
+	for _, v := range perm(10000) {
+		if sl.Search(v) != v {
+			t.Fatalf("didn't find %v", v)
+		}
+	}
+	for _, v := range perm(10000)[:5000] {
+		if !sl.Delete(v) {
+			t.Fatalf("didn't delete %v", v)
+		}
+	}
+	checkSpans(t, sl)
+}
+
+func TestRangeByRank(t *testing.T) {
+	sl := New()
+	for _, v := range perm(100) {
+		sl.Insert(v)
+	}
+	if got, want := sl.RangeByRank(10, 14), rang(14)[9:]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := sl.RangeByRank(-5, 2), rang(2); !reflect.DeepEqual(got, want) {
+		t.Fatalf("clamped from: got %v, want %v", got, want)
+	}
+	if got, want := sl.RangeByRank(99, 200), rang(100)[98:]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("clamped to: got %v, want %v", got, want)
+	}
+	if got := sl.RangeByRank(14, 10); len(got) != 0 {
+		t.Fatalf("reversed ranks: got %v", got)
+	}
+	if got := New().RangeByRank(1, 10); len(got) != 0 {
+		t.Fatalf("empty list: got %v", got)
+	}
+}
+
+func TestResort(t *testing.T) {
+	sl := New()
+	for _, v := range perm(10) {
+		sl.Insert(v)
+	}
+	desc := sl.Resort(func(a, b Item) bool { return b.Less(a) })
+
+	var got []Item
+	for it := desc.NewIterator(); it.Valid(); it.Next() {
+		got = append(got, it.Value())
+	}
+	want := []Item{Int(9), Int(8), Int(7), Int(6), Int(5), Int(4), Int(3), Int(2), Int(1), Int(0)}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if desc.Search(Int(3)) != Int(3) || !desc.Delete(Int(3)) || desc.Search(Int(3)) != nil {
+		t.Fatal("search and delete should follow the new order")
+	}
+	if got := sl.ToInts(); !reflect.DeepEqual(got, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}) {
+		t.Fatalf("source list changed: %v", got)
+	}
+}
+
+// kv is an item ordered by key that carries a payload.
+type kv struct {
+	key, value int
+}
+
+func (a kv) Less(b Item) bool {
+	return a.key < b.(kv).key
+}
+
+func TestInsertMerge(t *testing.T) {
+	sl := New()
+	sum := func(old, new Item) Item {
+		return kv{key: old.(kv).key, value: old.(kv).value + new.(kv).value}
+	}
+	sl.InsertMerge(kv{key: 1, value: 3}, sum)
+	sl.InsertMerge(kv{key: 1, value: 4}, sum)
+	sl.InsertMerge(kv{key: 2, value: 5}, sum)
+
+	if sl.Len() != 2 {
+		t.Fatalf("len: want 2, got %d", sl.Len())
+	}
+	if got, want := sl.Search(kv{key: 1}), Item(kv{key: 1, value: 7}); got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := sl.Search(kv{key: 2}), Item(kv{key: 2, value: 5}); got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+// mutable is an item whose key can be changed while it is stored.
+type mutable struct {
+	key int
+}
+
+func (a *mutable) Less(b Item) bool {
+	return a.key < b.(*mutable).key
+}
+
+func TestIsSorted(t *testing.T) {
+	sl := New()
+	if !sl.IsSorted() {
+		t.Fatal("empty list should be sorted")
+	}
+	items := make([]*mutable, 10)
+	for i := range items {
+		items[i] = &mutable{key: i}
+		sl.Insert(items[i])
+	}
+	if !sl.IsSorted() {
+		t.Fatal("list should be sorted")
+	}
+	items[3].key = 7
+	if sl.IsSorted() {
+		t.Fatal("mutated key should break the order")
+	}
+}
+
+func TestInsertIf(t *testing.T) {
+	sl := New()
+	larger := func(old, new Item) bool {
+		return new.(kv).value > old.(kv).value
+	}
+	sl.InsertIf(kv{key: 1, value: 5}, larger)
+	sl.InsertIf(kv{key: 1, value: 3}, larger)
+	if got, want := sl.Search(kv{key: 1}), Item(kv{key: 1, value: 5}); got != want {
+		t.Fatalf("smaller payload: got %v, want %v", got, want)
+	}
+	sl.InsertIf(kv{key: 1, value: 8}, larger)
+	if got, want := sl.Search(kv{key: 1}), Item(kv{key: 1, value: 8}); got != want {
+		t.Fatalf("larger payload: got %v, want %v", got, want)
+	}
+	if sl.Len() != 1 {
+		t.Fatalf("len: want 1, got %d", sl.Len())
+	}
+
+	// A declined replace leaves no trace.
+	sl = NewVersioned()
+	sl.InsertIf(kv{key: 1, value: 5}, larger)
+	overwrites := 0
+	sl.SetOverwriteCallback(func(Item) { overwrites++ })
+	since := sl.Version()
+	sl.InsertIf(kv{key: 1, value: 3}, larger)
+	if overwrites != 0 || sl.Stats().Overwrites != 0 {
+		t.Fatalf("declined replace: want no overwrite, got %d callbacks and %d counted", overwrites, sl.Stats().Overwrites)
+	}
+	if it := sl.NewIteratorSince(since); it.Valid() {
+		t.Fatalf("declined replace: want no change since version %d, got %v", since, it.Value())
+	}
+	sl.InsertIf(kv{key: 1, value: 8}, larger)
+	if overwrites != 1 || sl.Stats().Overwrites != 1 || !sl.NewIteratorSince(since).Valid() {
+		t.Fatal("accepted replace: want one overwrite and a new version")
+	}
+}
+
+const benchmarkListSize = 10000
+
+func BenchmarkInsert(b *testing.B) {
+	b.StopTimer()
+	insertP := perm(benchmarkListSize)
+	b.StartTimer()
+	i := 0
+	for i < b.N {
+		sl := New()
+		for _, item := range insertP {
+			sl.Insert(item)
+			i++
+			if i >= b.N {
+				return
+			}
+		}
+	}
+}
+
+func BenchmarkSearch(b *testing.B) {
+	b.StopTimer()
+	insertP := perm(benchmarkListSize)
+	searchP := perm(benchmarkListSize)
+	b.StartTimer()
+	i := 0
+	for i < b.N {
+		b.StopTimer()
+		tr := New()
+		for _, v := range insertP {
+			tr.Insert(v)
+		}
+		b.StartTimer()
+		for _, item := range searchP {
+			tr.Search(item)
+			i++
+			if i >= b.N {
+				return
+			}
+		}
+	}
+}
+
+func BenchmarkDeleteInsert(b *testing.B) {
+	b.StopTimer()
+	insertP := perm(benchmarkListSize)
+	sl := New()
+	for _, item := range insertP {
+		sl.Insert(item)
+	}
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		sl.Delete(insertP[i%benchmarkListSize])
+		sl.Insert(insertP[i%benchmarkListSize])
+	}
+}
+
+func BenchmarkDeleteInsertNoPointers(b *testing.B) {
+	for _, noPtrs := range []bool{false, true} {
+		b.Run(fmt.Sprintf("noPtrs=%v", noPtrs), func(b *testing.B) {
+			insertP := perm(benchmarkListSize)
+			sl := New()
+			sl.SetItemsNoPointers(noPtrs)
+			for _, item := range insertP {
+				sl.Insert(item)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				sl.Delete(insertP[i%benchmarkListSize])
+				sl.Insert(insertP[i%benchmarkListSize])
+			}
+		})
+	}
+}
+
+// rangeSink keeps the ranges of BenchmarkRange on the heap, as they are
+// when they outlive the function creating them.
+var rangeSink *Range
+
+func BenchmarkRange(b *testing.B) {
+	sl := New()
+	for _, item := range perm(benchmarkListSize) {
+		sl.Insert(item)
+	}
+	keys := rang(benchmarkListSize + 10)
+	walk := func(r *Range) {
+		for ; r.Valid(); r.Next() {
+			_ = r.Value()
+		}
+	}
+	b.Run("new", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			rangeSink = sl.NewRange(keys[i%benchmarkListSize], keys[i%benchmarkListSize+10])
+			walk(rangeSink)
+		}
+	})
+	b.Run("pooled", func(b *testing.B) {
+		pool := sync.Pool{New: func() interface{} { return new(Range) }}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			r := pool.Get().(*Range)
+			sl.NewRangeInto(r, keys[i%benchmarkListSize], keys[i%benchmarkListSize+10])
+			walk(r)
+			pool.Put(r)
+		}
+	})
+}
+
+// costly is an Int whose Less is expensive and counted.
+type costly int
+
+var costlyCalls int
+
+func (a costly) Less(b Item) bool {
+	costlyCalls++
+	sum := 0
+	for i := 0; i < 100; i++ {
+		sum += i
+	}
+	return int(a)+sum < int(b.(costly))+sum
+}
+
+func BenchmarkCostlyLess(b *testing.B) {
+	sl := New()
+	keys := make([]Item, benchmarkListSize)
+	for i, v := range rand.Perm(benchmarkListSize) {
+		keys[i] = costly(v)
+		sl.Insert(keys[i])
+	}
+	b.ResetTimer()
+	costlyCalls = 0
+	for i := 0; i < b.N; i++ {
+		key := keys[i%benchmarkListSize]
+		sl.Search(key)
+		sl.Delete(key)
+		sl.Insert(key)
+	}
+	b.ReportMetric(float64(costlyCalls)/float64(b.N), "less/op")
+}
+
+// costlyKey derives the key costly.Less compares, doing its work once.
+func costlyKey(item Item) Item {
+	costlyCalls++
+	sum := 0
+	for i := 0; i < 100; i++ {
+		sum += i
+	}
+	return Int(int(item.(costly)) + sum)
+}
+
+func BenchmarkCostlyKey(b *testing.B) {
+	sl := NewWithKey(costlyKey)
+	keys := make([]Item, benchmarkListSize)
+	for i, v := range rand.Perm(benchmarkListSize) {
+		keys[i] = costly(v)
+		sl.Insert(keys[i])
+	}
+	b.ResetTimer()
+	costlyCalls = 0
+	for i := 0; i < b.N; i++ {
+		key := keys[i%benchmarkListSize]
+		sl.Search(key)
+		sl.Delete(key)
+		sl.Insert(key)
+	}
+	b.ReportMetric(float64(costlyCalls)/float64(b.N), "key/op")
+}
+
+func BenchmarkDelete(b *testing.B) {
+	b.StopTimer()
+	insertP := perm(benchmarkListSize)
+	removeP := perm(benchmarkListSize)
+	b.StartTimer()
+	i := 0
+	for i < b.N {
+		b.StopTimer()
+		sl := New()
+		for _, v := range insertP {
+			sl.Insert(v)
+		}
+		b.StartTimer()
+		for _, item := range removeP {
+			sl.Delete(item)
+			i++
+			if i >= b.N {
+				return
+			}
+		}
+		if sl.Len() > 0 {
+			panic(sl.Len())
+		}
+	}
+}
+
+func BenchmarkNewSmall(b *testing.B) {
+	items := perm(8)
+	b.Run("PerListRand", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			sl := New()
+			for _, item := range items {
+				sl.Insert(item)
+			}
+		}
+	})
+	b.Run("SharedRand", func(b *testing.B) {
+		b.ReportAllocs()
+		r := rand.New(rand.NewSource(time.Now().UnixNano()))
+		for i := 0; i < b.N; i++ {
+			sl := NewWithRand(r)
+			for _, item := range items {
+				sl.Insert(item)
+			}
+		}
+	})
+}
+
+const arenaBenchmarkListSize = 10000000
+
+func BenchmarkBuild(b *testing.B) {
+	items := rang(arenaBenchmarkListSize)
+	for _, bm := range []struct {
+		name string
+		new  func() *SkipList
+	}{
+		{"Heap", New},
+		{"Arena", NewWithArena},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			var stats runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&stats)
+			pause := stats.PauseTotalNs
+			for i := 0; i < b.N; i++ {
+				sl := bm.new()
+				for _, item := range items {
+					sl.Insert(item)
+				}
+			}
+			runtime.ReadMemStats(&stats)
+			b.ReportMetric(float64(stats.PauseTotalNs-pause)/float64(b.N), "gc-pause-ns/op")
+		})
+	}
+}
+
+func TestFindByWeight(t *testing.T) {
+	sl := NewWeighted(func(item Item) float64 { return float64(item.(kv).value) })
+	check := func() {
+		t.Helper()
+		var acc float64
+		for it := sl.NewIterator(); it.Valid(); it.Next() {
+			acc += float64(it.Value().(kv).value)
+			for _, target := range []float64{acc - 0.5, acc} {
+				if got := sl.FindByWeight(target); got != it.Value() {
+					t.Fatalf("FindByWeight(%v): want %v, got %v", target, it.Value(), got)
+				}
+			}
+		}
+		if acc != sl.TotalWeight() {
+			t.Fatalf("TotalWeight: want %v, got %v", acc, sl.TotalWeight())
+		}
+		if got := sl.FindByWeight(acc + 1); got != nil {
+			t.Fatalf("FindByWeight past the total: got %v", got)
+		}
+	}
+	for _, k := range rand.Perm(1000) {
+		sl.Insert(kv{k, k%5 + 1})
+	}
+	check()
+	for _, k := range rand.Perm(1000)[:300] {
+		sl.Insert(kv{k, k%3 + 1})
+	}
+	check()
+	for _, k := range rand.Perm(1000)[:400] {
+		sl.Delete(kv{key: k})
+	}
+	check()
+	sl.PopLE(kv{key: 500})
+	sl.Retain(kv{key: 100}, kv{key: 900})
+	check()
+	for _, k := range rand.Perm(1000)[:300] {
+		sl.SoftDelete(kv{key: k})
+	}
+	check()
+	for _, k := range rand.Perm(1000)[:300] {
+		sl.Insert(kv{k, k%4 + 1})
+	}
+	check()
+	sl.SoftDelete(kv{key: 200})
+	sl.Compact()
+	check()
+	sl.Replace([]Item{kv{1, 2}, kv{2, 3}, kv{3, 1}})
+	check()
+	if got := sl.FindByWeight(4); got != (kv{2, 3}) {
+		t.Fatalf("FindByWeight(4): want {2 3}, got %v", got)
+	}
+
+	ones := NewWeighted(func(item Item) float64 { return float64(item.(Int)) })
+	for i := 1; i <= 5; i++ {
+		ones.Insert(Int(i))
+	}
+	ones.SoftDelete(Int(5))
+	if ones.TotalWeight() != 10 || ones.FindByWeight(14) != nil || ones.PrefixSum(Int(5)) != 10 {
+		t.Fatalf("soft deleted 5: got total %v, FindByWeight(14) %v", ones.TotalWeight(), ones.FindByWeight(14))
+	}
+	ones.SoftDelete(Int(1))
+	if got := ones.FindByWeight(0); got != Int(2) {
+		t.Fatalf("FindByWeight(0) past a soft deleted first item: want 2, got %v", got)
+	}
+	ones.Insert(Int(5))
+	if ones.TotalWeight() != 14 || ones.FindByWeight(14) != Int(5) {
+		t.Fatalf("revived 5: got total %v", ones.TotalWeight())
+	}
+}
+
+func TestForEachPair(t *testing.T) {
+	sl := New()
+	var pairs [][2]Item
+	collect := func(a, b Item) { pairs = append(pairs, [2]Item{a, b}) }
+	sl.ForEachPair(collect)
+	sl.Insert(Int(0))
+	sl.ForEachPair(collect)
+	if len(pairs) != 0 {
+		t.Fatalf("want no pairs, got %v", pairs)
+	}
+	for _, v := range perm(5) {
+		sl.Insert(v)
+	}
+	sl.ForEachPair(collect)
+	want := [][2]Item{{Int(0), Int(1)}, {Int(1), Int(2)}, {Int(2), Int(3)}, {Int(3), Int(4)}}
+	if !reflect.DeepEqual(pairs, want) {
+		t.Fatalf("want %v, got %v", want, pairs)
+	}
+}
+
+func TestFixed(t *testing.T) {
+	const capacity = 100
+	sl := NewFixed(capacity, 8)
+	for _, v := range perm(capacity) {
+		sl.Insert(v)
+	}
+	if sl.Len() != capacity {
+		t.Fatalf("len: want %d, got %d", capacity, sl.Len())
+	}
+	checkSpans(t, sl)
+
+	// Overwriting an item needs no new node.
+	sl.Insert(Int(50))
+	if err := sl.TryInsertItem(Int(capacity)); err != ErrFull {
+		t.Fatalf("want ErrFull, got %v", err)
+	}
+	func() {
+		defer func() {
+			if r := recover(); r != ErrFull {
+				t.Fatalf("want ErrFull panic, got %v", r)
+			}
+		}()
+		sl.Insert(Int(-1))
+	}()
+	if sl.Len() != capacity || sl.Search(Int(-1)) != nil {
+		t.Fatal("a failed insert changed the list")
+	}
+	checkSpans(t, sl)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		sl.Delete(Int(10))
+		sl.Insert(Int(10))
+	})
+	if allocs != 0 {
+		t.Fatalf("want no allocations, got %v", allocs)
+	}
+	for i, v := range sl.ToInts() {
+		if v != i {
+			t.Fatalf("item %d: want %d, got %d", i, i, v)
+		}
+	}
+
+	// A node dropped by DeleteCompact goes back to the slab as well.
+	if !sl.DeleteCompact(Int(20)) {
+		t.Fatal("DeleteCompact(20) missed")
+	}
+	if err := sl.TryInsertItem(Int(capacity)); err != nil {
+		t.Fatalf("insert after DeleteCompact: %v", err)
+	}
+	if sl.Len() != capacity {
+		t.Fatalf("len: want %d, got %d", capacity, sl.Len())
+	}
+
+	// Pinned and pending nodes keep their place in the slab until freed.
+	sl.Pin(Int(30))
+	sl.Delete(Int(30))
+	sl.SetDeferredFree(true)
+	sl.Delete(Int(40))
+	if err := sl.TryInsertItem(Int(30)); err != ErrFull {
+		t.Fatalf("pinned and pending nodes: want ErrFull below capacity, got %v", err)
+	}
+	sl.Collect()
+	if err := sl.TryInsertItem(Int(40)); err != nil {
+		t.Fatalf("insert after Collect: %v", err)
+	}
+	if err := sl.TryInsertItem(Int(30)); err != ErrFull {
+		t.Fatalf("pinned node: want ErrFull, got %v", err)
+	}
+	sl.Unpin(Int(30))
+	if err := sl.TryInsertItem(Int(30)); err != nil {
+		t.Fatalf("insert after Unpin: %v", err)
+	}
+	if sl.Len() != capacity {
+		t.Fatalf("len: want %d, got %d", capacity, sl.Len())
+	}
+}
+
+func TestRangeCursor(t *testing.T) {
+	sl := New()
+	for _, v := range perm(10) {
+		sl.Insert(v)
+	}
+	r := sl.NewRange(Int(2), Int(7))
+	if !r.Valid() || r.Value() != Int(2) {
+		t.Fatalf("want the cursor on 2, got %v", r.Value())
+	}
+	r.Next()
+	r.Next()
+	r.Prev()
+	if r.Value() != Int(3) {
+		t.Fatalf("want 3, got %v", r.Value())
+	}
+	r.Prev()
+	r.Prev()
+	if r.Valid() || !r.Begin() {
+		t.Fatal("want the cursor before the range")
+	}
+	r.Prev()
+	r.Next()
+	if r.Value() != Int(2) {
+		t.Fatalf("want 2, got %v", r.Value())
+	}
+	for i := 0; i < 6; i++ {
+		r.Next()
+	}
+	if r.Valid() || !r.End() {
+		t.Fatal("want the cursor past the range")
+	}
+	r.Prev()
+	if r.Value() != Int(7) {
+		t.Fatalf("want 7, got %v", r.Value())
+	}
+
+	// The range reaching the last item ends at nil.
+	r = sl.NewRange(Int(8), Int(20))
+	r.Next()
+	r.Next()
+	r.Prev()
+	if r.Value() != Int(9) {
+		t.Fatalf("want 9, got %v", r.Value())
+	}
+
+	r = sl.NewRange(Int(20), Int(30))
+	if r.Valid() {
+		t.Fatal("want an empty range")
+	}
+	r.Prev()
+	r.Next()
+	if r.Valid() {
+		t.Fatal("want an empty range")
+	}
+}
+
+func TestCountRangeFunc(t *testing.T) {
+	sl := New()
+	for _, v := range perm(100) {
+		sl.Insert(v)
+	}
+	even := func(item Item) bool { return item.(Int)%2 == 0 }
+	if n := sl.CountRangeFunc(Int(10), Int(20), even); n != 6 {
+		t.Fatalf("want 6, got %d", n)
+	}
+	if n := sl.CountRangeFunc(Int(20), Int(10), even); n != 0 {
+		t.Fatalf("reversed range: want 0, got %d", n)
+	}
+	if n := sl.CountRangeFunc(Int(200), Int(300), even); n != 0 {
+		t.Fatalf("range past the end: want 0, got %d", n)
+	}
+}
+
+func TestSearchIndex(t *testing.T) {
+	sl := New()
+	for _, v := range []Int{0, 2, 4, 6} {
+		sl.Insert(v)
+	}
+	for key, want := range map[Int]int{-1: 0, 0: 0, 3: 2, 6: 3, 7: 4} {
+		if got := sl.SearchIndex(key); got != want {
+			t.Errorf("SearchIndex(%d): want %d, got %d", key, want, got)
+		}
+	}
+}
+
+func TestForEachGroup(t *testing.T) {
+	sl := NewMultiset()
+	for _, e := range []kv{{2, 5}, {1, 1}, {2, 7}, {3, 4}, {1, 2}, {2, 1}} {
+		sl.Insert(e)
+	}
+	sum := func(items []Item) Item {
+		total := kv{key: items[0].(kv).key}
+		for _, item := range items {
+			total.value += item.(kv).value
+		}
+		return total
+	}
+	var got []kv
+	sl.ForEachGroup(sum, func(key, aggregated Item) {
+		if key.(kv).key != aggregated.(kv).key {
+			t.Fatalf("key %v does not match its aggregate %v", key, aggregated)
+		}
+		got = append(got, aggregated.(kv))
+	})
+	want := []kv{{1, 3}, {2, 13}, {3, 4}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+}
+
+func TestOverwriteCallback(t *testing.T) {
+	sl := New()
+	var got []Item
+	sl.SetOverwriteCallback(func(item Item) { got = append(got, item) })
+	sl.Insert(kv{1, 10})
+	sl.Insert(kv{2, 20})
+	sl.Insert(kv{1, 11})
+	if want := []Item{kv{1, 11}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+}
+
+func TestShrinkToFit(t *testing.T) {
+	sl := New()
+	for _, v := range rang(1000000) {
+		sl.Insert(v)
+	}
+	sl.Retain(Int(0), Int(9999))
+	sl.ShrinkToFit()
+	// 4^7 >= 10000 > 4^6
+	if sl.maxLevel != 7 || sl.level > 7 {
+		t.Fatalf("want max level 7, got %d with level %d", sl.maxLevel, sl.level)
+	}
+	checkSpans(t, sl)
+	for i, v := range sl.ToInts() {
+		if v != i {
+			t.Fatalf("item %d: want %d, got %d", i, i, v)
+		}
+	}
+	if sl.Len() != 10000 {
+		t.Fatalf("len: want 10000, got %d", sl.Len())
+	}
+	sl.Insert(Int(-1))
+	if sl.Search(Int(-1)) == nil {
+		t.Fatal("insert after ShrinkToFit failed")
+	}
+}
+
+func TestPartition(t *testing.T) {
+	sl := New()
+	for _, v := range perm(100) {
+		sl.Insert(v)
+	}
+	parts := sl.Partition(4)
+	if len(parts) != 4 {
+		t.Fatalf("want 4 parts, got %d", len(parts))
+	}
+	for k, part := range parts {
+		if len(part) != 25 {
+			t.Fatalf("part %d: want 25 items, got %d", k, len(part))
+		}
+		for i, item := range part {
+			if want := Int(25*k + i); item != want {
+				t.Fatalf("part %d item %d: want %v, got %v", k, i, want, item)
+			}
+		}
+	}
+	if parts := sl.Partition(3); len(parts[0]) != 33 || len(parts[1]) != 33 || len(parts[2]) != 34 {
+		t.Fatalf("want parts of 33, 33 and 34 items, got %v", parts)
+	}
+
+	small := New()
+	small.Insert(Int(1))
+	small.Insert(Int(2))
+	if parts := small.Partition(5); !reflect.DeepEqual(parts, [][]Item{{Int(1)}, {Int(2)}}) {
+		t.Fatalf("want a part per item, got %v", parts)
+	}
+	if parts := New().Partition(2); len(parts) != 0 {
+		t.Fatalf("want no parts, got %v", parts)
+	}
+}
+
+func TestSearchAll(t *testing.T) {
+	sl := NewMultiset()
+	for _, e := range []kv{{1, 0}, {2, 1}, {3, 0}, {2, 2}, {2, 3}} {
+		sl.Insert(e)
+	}
+	if got, want := sl.SearchAll(kv{key: 2}), []Item{kv{2, 1}, kv{2, 2}, kv{2, 3}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	if got := sl.SearchAll(kv{key: 4}); len(got) != 0 {
+		t.Fatalf("want nothing, got %v", got)
+	}
+}
+
+func TestRepair(t *testing.T) {
+	sl := New()
+	for _, v := range perm(1000) {
+		sl.Insert(v)
+	}
+	// Point every express lane to the wrong nodes and scramble the spans.
+	var high []*node
+	for x := sl.header.forward[0]; x != nil; x = x.forward[0] {
+		if len(x.forward) > 1 {
+			high = append(high, x)
+		}
+	}
+	for _, x := range append(high, sl.header) {
+		for i := 1; i < len(x.forward); i++ {
+			x.forward[i] = high[rand.Intn(len(high))]
+			x.span[i] = 0
+		}
+	}
+	sl.Repair()
+	checkSpans(t, sl)
+	for _, v := range perm(1000) {
+		if sl.Search(v) != v {
+			t.Fatalf("Search(%v) failed after Repair", v)
+		}
+	}
+	if sl.Len() != 1000 || sl.Search(Int(1000)) != nil {
+		t.Fatal("Repair changed the contents")
+	}
+}
+
+func TestSearchClosest(t *testing.T) {
+	sl := New()
+	for _, v := range []Int{10, 20, 30} {
+		sl.Insert(v)
+	}
+	dist := func(a, b Item) float64 { return math.Abs(float64(a.(Int) - b.(Int))) }
+	for i, tc := range []struct {
+		key  Int
+		mode ClosestMode
+		want Item
+	}{
+		{20, Floor, Int(20)},
+		{24, Floor, Int(20)},
+		{5, Floor, nil},
+		{35, Floor, Int(30)},
+		{24, Ceiling, Int(30)},
+		{35, Ceiling, nil},
+		{5, Ceiling, Int(10)},
+		{24, Nearest(dist), Int(20)},
+		{26, Nearest(dist), Int(30)},
+		{25, Nearest(dist), Int(20)},
+		{5, Nearest(dist), Int(10)},
+		{35, Nearest(dist), Int(30)},
+	} {
+		if got := sl.SearchClosest(tc.key, tc.mode); got != tc.want {
+			t.Errorf("case %d: SearchClosest(%v): want %v, got %v", i, tc.key, tc.want, got)
+		}
+	}
+	if got := New().SearchClosest(Int(1), Nearest(dist)); got != nil {
+		t.Fatalf("empty list: want nil, got %v", got)
+	}
+
+	sl.SoftDelete(Int(20))
+	if got := sl.SearchClosest(Int(20), Floor); got != Int(10) {
+		t.Fatalf("soft deleted, floor: want 10, got %v", got)
+	}
+	if got := sl.SearchClosest(Int(20), Ceiling); got != Int(30) {
+		t.Fatalf("soft deleted, ceiling: want 30, got %v", got)
+	}
+}
+
+func TestStreamRange(t *testing.T) {
+	sl := New()
+	for _, v := range perm(100) {
+		sl.Insert(v)
+	}
+	ch := make(chan Item)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- sl.StreamRange(context.Background(), Int(10), Int(20), ch)
+		close(ch)
+	}()
+	var got []Item
+	for item := range ch {
+		got = append(got, item)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("want no error, got %v", err)
+	}
+	if want := rang(21)[10:]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+}
+
+func TestStreamRangeCancel(t *testing.T) {
+	sl := New()
+	for _, v := range perm(100) {
+		sl.Insert(v)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan Item)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- sl.StreamRange(ctx, Int(10), Int(20), ch)
+	}()
+	for i := 0; i < 3; i++ {
+		if item := <-ch; item != Int(10+i) {
+			t.Fatalf("want %d, got %v", 10+i, item)
+		}
+	}
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Fatalf("want context.Canceled, got %v", err)
+	}
+}
+
+func TestPairsWithin(t *testing.T) {
+	sl := New()
+	for _, v := range []Int{10, 0, 11, 2, 1} {
+		sl.Insert(v)
+	}
+	dist := func(a, b Item) float64 { return float64(b.(Int) - a.(Int)) }
+	var got [][2]Item
+	sl.PairsWithin(2, dist, func(a, b Item) { got = append(got, [2]Item{a, b}) })
+	want := [][2]Item{{Int(0), Int(1)}, {Int(1), Int(2)}, {Int(10), Int(11)}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	got = nil
+	sl.PairsWithin(3, dist, func(a, b Item) { got = append(got, [2]Item{a, b}) })
+	if len(got) != 4 {
+		t.Fatalf("threshold 3: want 4 pairs, got %v", got)
+	}
+}
+
+func TestMergeCombineIterator(t *testing.T) {
+	a, b, c := New(), New(), New()
+	for _, e := range []kv{{1, 1}, {3, 3}, {5, 5}} {
+		a.Insert(e)
+	}
+	for _, e := range []kv{{2, 20}, {3, 30}} {
+		b.Insert(e)
+	}
+	for _, e := range []kv{{3, 300}, {5, 500}, {6, 600}} {
+		c.Insert(e)
+	}
+	sum := func(x, y Item) Item { return kv{x.(kv).key, x.(kv).value + y.(kv).value} }
+	var got []Item
+	for it := NewMergeCombineIterator(sum, a, b, c); it.Valid(); it.Next() {
+		got = append(got, it.Value())
+	}
+	want := []Item{kv{1, 1}, kv{2, 20}, kv{3, 333}, kv{5, 505}, kv{6, 600}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	if NewMergeCombineIterator(sum).Valid() || NewMergeCombineIterator(sum, New()).Valid() {
+		t.Fatal("want nothing to merge")
+	}
+}
+
+func TestNewWithKey(t *testing.T) {
+	costlyCalls = 0
+	sl := NewWithKey(costlyKey)
+	for _, v := range rand.Perm(100) {
+		sl.Insert(costly(v))
+	}
+	if costlyCalls != 100 {
+		t.Fatalf("inserts: want one key per item, got %d", costlyCalls)
+	}
+	costlyCalls = 0
+	if sl.Search(costly(42)) != costly(42) || sl.Search(costly(100)) != nil {
+		t.Fatal("search by key failed")
+	}
+	if costlyCalls != 2 {
+		t.Fatalf("searches: want one key each, got %d", costlyCalls)
+	}
+	if !sl.Delete(costly(42)) || sl.Delete(costly(42)) || sl.Len() != 99 {
+		t.Fatal("delete by key failed")
+	}
+	sl.Insert(costly(7))
+	if sl.Len() != 99 {
+		t.Fatal("an equal item should replace the stored one")
+	}
+	want := 0
+	for it := sl.NewIterator(); it.Valid(); it.Next() {
+		if want == 42 {
+			want++
+		}
+		if it.Value() != costly(want) {
+			t.Fatalf("want %d, got %v", want, it.Value())
+		}
+		want++
+	}
+	if got := sl.GetRange(costly(10), costly(12)); !reflect.DeepEqual(got, []Item{costly(10), costly(11), costly(12)}) {
+		t.Fatalf("range: got %v", got)
+	}
+	if err := sl.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCIString(t *testing.T) {
+	sl := New()
+	for _, s := range []string{"carol", "Bob", "alice", "Dave"} {
+		sl.Insert(NewCIString(s))
+	}
+	if got := sl.Search(NewCIString("bob")); got == nil || got.(CIString).String() != "Bob" {
+		t.Fatalf("want Bob, got %v", got)
+	}
+	sl.Insert(NewCIString("ALICE"))
+	var got []string
+	for it := sl.NewIterator(); it.Valid(); it.Next() {
+		got = append(got, it.Value().(CIString).String())
+	}
+	if want := []string{"ALICE", "Bob", "carol", "Dave"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+}
+
+func TestAtFraction(t *testing.T) {
+	sl := New()
+	if sl.AtFraction(0.5) != nil {
+		t.Fatal("empty list: want nil")
+	}
+	for _, v := range perm(100) {
+		sl.Insert(v)
+	}
+	for f, want := range map[float64]Item{-1: Int(0), 0: Int(0), 0.25: Int(25), 0.5: Int(50), 1: Int(99), 2: Int(99)} {
+		if got := sl.AtFraction(f); got != want {
+			t.Errorf("AtFraction(%v): want %v, got %v", f, want, got)
+		}
+	}
+}
+
+func TestMaxSearchDepth(t *testing.T) {
+	sl := New()
+	for _, v := range perm(100000) {
+		sl.Insert(v)
+	}
+	// About 4*log4(100000) = 33 hops are expected.
+	if d := sl.MaxSearchDepth(); d < 8 || d > 150 {
+		t.Fatalf("want a logarithmic depth, got %d", d)
+	}
+
+	flat := New()
+	flat.SetLevelFunc(func() int32 { return 1 })
+	for _, v := range perm(100) {
+		flat.Insert(v)
+	}
+	if d := flat.MaxSearchDepth(); d != 100 {
+		t.Fatalf("flat list: want 100, got %d", d)
+	}
+	if d := New().MaxSearchDepth(); d != 0 {
+		t.Fatalf("empty list: want 0, got %d", d)
+	}
+}
+
+func TestIterateUntil(t *testing.T) {
+	sl := New()
+	for _, v := range perm(100) {
+		sl.Insert(v)
+	}
+	var visited []Item
+	sum := 0
+	sl.IterateUntil(Int(0), func(item Item) bool {
+		visited = append(visited, item)
+		sum += int(item.(Int))
+		return sum > 10
+	})
+	if want := rang(6); !reflect.DeepEqual(visited, want) {
+		t.Fatalf("want %v, got %v", want, visited)
+	}
+	visited = nil
+	sl.IterateUntil(Int(98), func(item Item) bool {
+		visited = append(visited, item)
+		return false
+	})
+	if want := []Item{Int(98), Int(99)}; !reflect.DeepEqual(visited, want) {
+		t.Fatalf("want %v, got %v", want, visited)
+	}
+}
+
+func TestMaybeShrink(t *testing.T) {
+	sl := New()
+	sl.PrewarmFreeList(10000, 4)
+	sl.SetMaxNodeLevel(4)
+	for _, v := range rang(10000) {
+		sl.Insert(v)
+	}
+	for _, v := range rang(9990) {
+		sl.Delete(v)
+	}
+	if n := sl.MaybeShrink(); n != 0 {
+		t.Fatalf("first call: want nothing released, got %d", n)
+	}
+	// Steady churn of at most 10 nodes.
+	for i := 0; i < 100; i++ {
+		for _, v := range rang(10) {
+			sl.Insert(v)
+		}
+		for _, v := range rang(10) {
+			sl.Delete(v)
+		}
+	}
+	if n := sl.MaybeShrink(); n != 9980 {
+		t.Fatalf("want 9980 idle nodes released, got %d", n)
+	}
+	if got := cap(sl.freelist.freelist); got != 20 {
+		t.Fatalf("want the capacity shrunk to 20, got %d", got)
+	}
+	for _, v := range rang(10) {
+		sl.Insert(v)
+	}
+	if sl.Len() != 20 {
+		t.Fatalf("len: want 20, got %d", sl.Len())
+	}
+	checkSpans(t, sl)
+}
+
+func TestRangeBounds(t *testing.T) {
+	sl := New()
+	for _, v := range perm(100) {
+		sl.Insert(v)
+	}
+	for _, tc := range []struct {
+		begin, end  Int
+		first, last Item
+		count       int
+	}{
+		{20, 40, Int(20), Int(40), 21},
+		{-10, 5, Int(0), Int(5), 6},
+		{95, 200, Int(95), Int(99), 5},
+		{40, 20, nil, nil, 0},
+		{100, 200, nil, nil, 0},
+	} {
+		first, last, count := sl.RangeBounds(tc.begin, tc.end)
+		if first != tc.first || last != tc.last || count != tc.count {
+			t.Errorf("RangeBounds(%v, %v): want (%v, %v, %d), got (%v, %v, %d)",
+				tc.begin, tc.end, tc.first, tc.last, tc.count, first, last, count)
+		}
+	}
+	sparse := New()
+	sparse.Insert(Int(10))
+	sparse.Insert(Int(20))
+	if first, last, count := sparse.RangeBounds(Int(12), Int(18)); first != nil || last != nil || count != 0 {
+		t.Fatalf("gap: want an empty range, got (%v, %v, %d)", first, last, count)
+	}
+}
+
+func TestNthGreater(t *testing.T) {
+	sl := New()
+	for _, v := range perm(100) {
+		sl.Insert(v)
+	}
+	if item, ok := sl.NthGreater(Int(50), 5); !ok || item != Int(55) {
+		t.Fatalf("want 55, got %v, %v", item, ok)
+	}
+	if item, ok := sl.NthGreater(Int(-10), 1); !ok || item != Int(0) {
+		t.Fatalf("want 0, got %v, %v", item, ok)
+	}
+	if item, ok := sl.NthGreater(Int(90), 9); !ok || item != Int(99) {
+		t.Fatalf("want 99, got %v, %v", item, ok)
+	}
+	if item, ok := sl.NthGreater(Int(90), 10); ok {
+		t.Fatalf("want false past the end, got %v", item)
+	}
+	if _, ok := sl.NthGreater(Int(50), 0); ok {
+		t.Fatal("want false for k=0")
+	}
+}
+
+func TestInsertWindow(t *testing.T) {
+	const window = 10
+	sl := New()
+	for ts := 0; ts < 100; ts++ {
+		evicted := sl.InsertWindow(Int(ts), Int(ts-window))
+		if ts > window && evicted != 1 {
+			t.Fatalf("ts %d: want 1 evicted, got %d", ts, evicted)
+		}
+		if first := sl.nodeByRank(1).item; sl.Len() > window+1 || first.(Int) < Int(ts-window) {
+			t.Fatalf("ts %d: window holds %d items from %v", ts, sl.Len(), first)
+		}
+	}
+	checkSpans(t, sl)
+	if got := sl.ToInts(); !reflect.DeepEqual(got, []int{89, 90, 91, 92, 93, 94, 95, 96, 97, 98, 99}) {
+		t.Fatalf("want the last window, got %v", got)
+	}
+}
+
+func TestPrefixSum(t *testing.T) {
+	sl := NewWeighted(func(Item) float64 { return 1 })
+	for _, v := range rand.Perm(500) {
+		sl.Insert(Int(2 * v))
+	}
+	for _, v := range rand.Perm(200) {
+		sl.Delete(Int(2 * v))
+	}
+	for key := -1; key <= 1000; key++ {
+		if got, want := sl.PrefixSum(Int(key)), sl.Len()-sl.CountGreater(Int(key)); got != float64(want) {
+			t.Fatalf("PrefixSum(%d): want %d, got %v", key, want, got)
+		}
+	}
+
+	kvs := NewWeighted(func(item Item) float64 { return float64(item.(kv).value) })
+	for _, e := range []kv{{3, 30}, {1, 10}, {2, 20}} {
+		kvs.Insert(e)
+	}
+	if got := kvs.PrefixSum(kv{key: 2}); got != 30 {
+		t.Fatalf("want 30, got %v", got)
+	}
+}
+
+// zeroSource is a rand.Source always returning 0, so every level coin flip
+// comes up heads.
+type zeroSource struct{}
+
+func (zeroSource) Int63() int64 { return 0 }
+func (zeroSource) Seed(int64)   {}
+
+func TestCheckLevel(t *testing.T) {
+	sl := New()
+	for _, v := range perm(1000) {
+		sl.Insert(v)
+	}
+	if err := sl.CheckLevel(); err != nil {
+		t.Fatalf("fair generator: %v", err)
+	}
+	if got := sl.ExpectedLevel(); got != 5 {
+		t.Fatalf("want an expected level of 5, got %d", got)
+	}
+
+	sl = NewWithRand(rand.New(zeroSource{}))
+	for _, v := range perm(1000) {
+		sl.Insert(v)
+	}
+	if sl.level != DefaultMaxLevel {
+		t.Fatalf("want the level clamped to %d, got %d", DefaultMaxLevel, sl.level)
+	}
+	checkSpans(t, sl)
+	for _, v := range perm(1000)[:500] {
+		if sl.Search(v) != v || !sl.Delete(v) {
+			t.Fatalf("%v not found", v)
+		}
+	}
+	if sl.Len() != 500 {
+		t.Fatalf("len: want 500, got %d", sl.Len())
+	}
+	if err := sl.CheckLevel(); !errors.Is(err, ErrTooTall) {
+		t.Fatalf("want ErrTooTall, got %v", err)
+	}
+}
+
+func TestWalkDetailed(t *testing.T) {
+	levels := []int32{1, 2, 1, 3}
+	sl := New()
+	sl.SetLevelFunc(func() int32 {
+		lvl := levels[0]
+		levels = levels[1:]
+		return lvl
+	})
+	for _, v := range rang(4) {
+		sl.Insert(v)
+	}
+	var got [][]Item
+	sl.WalkDetailed(func(item Item, forward []Item) {
+		got = append(got, append([]Item{item}, forward...))
+	})
+	want := [][]Item{
+		{Int(0), Int(1)},
+		{Int(1), Int(2), Int(3)},
+		{Int(2), Int(3)},
+		{Int(3), nil, nil, nil},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+}
+
+func TestBuildFromChannel(t *testing.T) {
+	feed := func(items []Item) <-chan Item {
+		ch := make(chan Item)
+		go func() {
+			for _, item := range items {
+				ch <- item
+			}
+			close(ch)
+		}()
+		return ch
+	}
+	sl := BuildFromChannel(feed(rang(100)), true)
+	checkSpans(t, sl)
+	for i, v := range sl.ToInts() {
+		if v != i {
+			t.Fatalf("sorted: item %d is %d", i, v)
+		}
+	}
+	if sl.Len() != 100 || sl.Search(Int(42)) != Int(42) {
+		t.Fatal("sorted: wrong contents")
+	}
+
+	sl = BuildFromChannel(feed(append(perm(100), Int(7))), false)
+	checkSpans(t, sl)
+	if sl.Len() != 100 {
+		t.Fatalf("unsorted: len: want 100, got %d", sl.Len())
+	}
+	for i, v := range sl.ToInts() {
+		if v != i {
+			t.Fatalf("unsorted: item %d is %d", i, v)
+		}
+	}
+}
+
+func TestNewRangeSorted(t *testing.T) {
+	sl := New()
+	for _, v := range perm(10) {
+		sl.Insert(v)
+	}
+	collect := func(r *Range) (items []Item) {
+		r.ForEach(func(item Item) { items = append(items, item) })
+		return
+	}
+	want := collect(sl.NewRange(Int(2), Int(5)))
+	if got := collect(sl.NewRangeSorted(Int(5), Int(2))); !reflect.DeepEqual(got, want) || len(got) != 4 {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	if got := collect(sl.NewRangeSorted(Int(2), Int(5))); !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	if got := collect(sl.NewRange(Int(5), Int(2))); len(got) != 0 {
+		t.Fatalf("NewRange with reversed bounds: want nothing, got %v", got)
+	}
+}
+
+func TestLevelExtents(t *testing.T) {
+	if got := New().LevelExtents(); !reflect.DeepEqual(got, [][2]Item{{nil, nil}}) {
+		t.Fatalf("empty list: want one pair of nils, got %v", got)
+	}
+	levels := []int32{1, 2, 1, 3, 1}
+	sl := New()
+	sl.SetLevelFunc(func() int32 {
+		lvl := levels[0]
+		levels = levels[1:]
+		return lvl
+	})
+	for _, v := range rang(5) {
+		sl.Insert(v)
+	}
+	want := [][2]Item{{Int(0), Int(4)}, {Int(1), Int(3)}, {Int(3), Int(3)}}
+	if got := sl.LevelExtents(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+}
+
+func TestIsSubsetOf(t *testing.T) {
+	all, evens, odd := New(), New(), New()
+	for _, v := range perm(100) {
+		all.Insert(v)
+		if v.(Int)%2 == 0 {
+			evens.Insert(v)
+			odd.Insert(v)
+		}
+	}
+	odd.Insert(Int(101))
+	if !evens.IsSubsetOf(all) {
+		t.Fatal("evens should be a subset of all")
+	}
+	if odd.IsSubsetOf(all) {
+		t.Fatal("101 is not in all")
+	}
+	if all.IsSubsetOf(evens) {
+		t.Fatal("all is not a subset of evens")
+	}
+	if !New().IsSubsetOf(all) || !New().IsSubsetOf(New()) {
+		t.Fatal("an empty list is a subset of anything")
+	}
+
+	odd.SoftDelete(Int(101))
+	if !odd.IsSubsetOf(all) {
+		t.Fatal("101 is soft deleted, odd should be a subset of all")
+	}
+	all.SoftDelete(Int(50))
+	if odd.IsSubsetOf(all) {
+		t.Fatal("50 is soft deleted from all")
+	}
+}
+
+func TestPin(t *testing.T) {
+	sl := New()
+	for _, v := range perm(10) {
+		sl.Insert(v)
+	}
+	if sl.Pin(Int(20)) || sl.Unpin(Int(5)) {
+		t.Fatal("want false for an absent or unpinned item")
+	}
+	it := sl.NewIterator()
+	it.MoveTo(Int(5))
+	if !sl.Pin(Int(5)) {
+		t.Fatal("Pin(5) failed")
+	}
+	sl.Delete(Int(5))
+	if sl.Search(Int(5)) != nil || sl.Len() != 9 {
+		t.Fatal("a pinned item should still be deleted")
+	}
+	checkSpans(t, sl)
+	for i := 0; i < 10; i++ {
+		sl.Insert(Int(100 + i)) // nodes are recycled, but not the pinned one
+	}
+	if it.Value() != Int(5) {
+		t.Fatalf("want the pinned item, got %v", it.Value())
+	}
+	if !sl.Unpin(Int(5)) {
+		t.Fatal("Unpin(5) failed")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("want a panic using an iterator on an unpinned, deleted item")
+		}
+	}()
+	it.Value()
+}
+
+func TestApproxCountRange(t *testing.T) {
+	const listSize = 10000
+	sl := New()
+	sl.random = rand.New(rand.NewSource(1))
+	for _, v := range rang(listSize) {
+		sl.Insert(v)
+	}
+	r := rand.New(rand.NewSource(2))
+	const m = listSize / 2
+	var total float64
+	for i := 0; i < 1000; i++ {
+		begin := r.Intn(listSize - m)
+		got := sl.ApproxCountRange(Int(begin), Int(begin+m-1))
+		if got < 0 || got > listSize {
+			t.Fatalf("estimate %d out of range", got)
+		}
+		total += math.Abs(float64(got-m)) / m
+	}
+	if mean := total / 1000; mean > 0.6 {
+		t.Fatalf("mean relative error %.2f exceeds 0.6", mean)
+	}
+	if got := sl.ApproxCountRange(Int(10), Int(5)); got != 0 {
+		t.Fatalf("reversed range: want 0, got %d", got)
+	}
+	if got := New().ApproxCountRange(Int(0), Int(10)); got != 0 {
+		t.Fatalf("empty list: want 0, got %d", got)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	hash := func(item Item) uint64 { return uint64(item.(Int)) }
+	a, b := New(), New()
+	for _, v := range perm(100) {
+		a.Insert(v)
+	}
+	for _, v := range perm(100) {
+		b.Insert(v)
+	}
+	if a.Checksum(hash) != b.Checksum(hash) {
+		t.Fatal("equal contents should have equal checksums")
+	}
+	b.Delete(Int(50))
+	b.Insert(Int(100))
+	if a.Checksum(hash) == b.Checksum(hash) {
+		t.Fatal("different contents should have different checksums")
+	}
+	if New().Checksum(hash) == a.Checksum(hash) {
+		t.Fatal("an empty list should not match a full one")
+	}
+}
+
+func TestDeferredFree(t *testing.T) {
+	sl := New()
+	for _, v := range perm(10) {
+		sl.Insert(v)
+	}
+	sl.SetDeferredFree(true)
+	it := sl.NewIterator()
+	it.MoveTo(Int(3))
+	sl.Delete(Int(3))
+	sl.Retain(Int(0), Int(7))
+	sl.Insert(Int(20))
+	if sl.Search(Int(3)) != nil || sl.Len() != 8 {
+		t.Fatal("deleted items should be gone from the list")
+	}
+	checkSpans(t, sl)
+	if it.Value() != Int(3) {
+		t.Fatalf("want the deleted item readable, got %v", it.Value())
+	}
+	if n := sl.Collect(); n != 3 {
+		t.Fatalf("want 3 nodes collected, got %d", n)
+	}
+	if n := sl.Collect(); n != 0 {
+		t.Fatalf("want nothing left to collect, got %d", n)
+	}
+
+	// Replace sends the old nodes to Collect as well.
+	before := sl.NewIterator()
+	sl.Replace([]Item{Int(100), Int(101)})
+	if before.Value() != Int(0) {
+		t.Fatalf("want the replaced item readable, got %v", before.Value())
+	}
+	if got := sl.ToInts(); !reflect.DeepEqual(got, []int{100, 101}) {
+		t.Fatalf("after replace: got %v", got)
+	}
+	if n := sl.Collect(); n != 8 {
+		t.Fatalf("want 8 nodes collected after replace, got %d", n)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("want a panic using an iterator on a collected node")
+		}
+	}()
+	it.Value()
+}
+
+func TestCountEqual(t *testing.T) {
+	sl := New()
+	for _, v := range perm(10) {
+		sl.Insert(v)
+	}
+	sl.Insert(Int(4))
+	if sl.CountEqual(Int(4)) != 1 || sl.CountEqual(Int(10)) != 0 {
+		t.Fatal("want 1 for a present key and 0 for an absent one")
+	}
+	ms := NewMultiset()
+	for _, v := range []Int{1, 2, 2, 3, 2} {
+		ms.Insert(v)
+	}
+	for key, want := range map[Int]int{0: 0, 1: 1, 2: 3, 3: 1, 4: 0} {
+		if got := ms.CountEqual(key); got != want {
+			t.Errorf("multiset CountEqual(%d): want %d, got %d", key, want, got)
+		}
+	}
+}
+
+func TestRandomIterator(t *testing.T) {
+	sl := New()
+	for _, v := range perm(1000) {
+		sl.Insert(v)
+	}
+	var got []Item
+	for it := sl.NewRandomIterator(rand.New(rand.NewSource(1))); it.Valid(); it.Next() {
+		got = append(got, it.Value())
+	}
+	if len(got) != 1000 {
+		t.Fatalf("want 1000 items, got %d", len(got))
+	}
+	if reflect.DeepEqual(got, rang(1000)) {
+		t.Fatal("want a shuffled order")
+	}
+	sorted := New()
+	for _, item := range got {
+		sorted.Insert(item)
+	}
+	if sorted.Len() != 1000 || !reflect.DeepEqual(sorted.ToInts(), sl.ToInts()) {
+		t.Fatal("want every item visited once")
+	}
+	if New().NewRandomIterator(rand.New(rand.NewSource(1))).Valid() {
+		t.Fatal("empty list: want nothing to visit")
+	}
+}
+
+func TestDescending(t *testing.T) {
+	sl := NewDescending()
+	for _, v := range perm(10) {
+		sl.Insert(v)
+	}
+	if sl.GetByRank(1) != Int(9) || sl.GetByRank(10) != Int(0) || sl.GetByRank(11) != nil {
+		t.Fatalf("want 9 ranked first and 0 last, got %v and %v", sl.GetByRank(1), sl.GetByRank(10))
+	}
+	var down, up []Item
+	for it := sl.NewIterator(); it.Valid(); it.Next() {
+		down = append(down, it.Value())
+	}
+	for it := sl.Ascending(); it.Valid(); it.Next() {
+		up = append(up, it.Value())
+	}
+	if !reflect.DeepEqual(up, rang(10)) {
+		t.Fatalf("ascending: want 0..9, got %v", up)
+	}
+	for i := range down {
+		if down[i] != up[len(up)-1-i] {
+			t.Fatalf("descending: want the reverse of %v, got %v", up, down)
+		}
+	}
+	if got := sl.GetRange(Int(7), Int(3)); !reflect.DeepEqual(got, []Item{Int(7), Int(6), Int(5), Int(4), Int(3)}) {
+		t.Fatalf("ranges follow the list order, got %v", got)
+	}
+	if got := sl.CountLess(Int(7)); got != 2 {
+		t.Fatalf("want 2 items before 7, got %d", got)
+	}
+
+	it := sl.Ascending()
+	it.MoveTo(Int(4))
+	if it.Value() != Int(4) {
+		t.Fatalf("MoveTo(4): want 4, got %v", it.Value())
+	}
+	if up := New().Ascending(); up.Valid() {
+		t.Fatal("empty list: want nothing")
+	}
+}
+
+func TestReverseIterator(t *testing.T) {
+	sl := New()
+	for _, v := range []Int{10, 20, 30} {
+		sl.Insert(v)
+	}
+	var got []Item
+	for it := sl.NewReverseIterator(); it.Valid(); it.Next() {
+		got = append(got, it.Value())
+	}
+	if want := []Item{Int(30), Int(20), Int(10)}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	it := sl.NewReverseIterator()
+	for key, want := range map[Int]Item{25: Int(20), 20: Int(20), 35: Int(30), 5: nil} {
+		it.MoveTo(key)
+		var got Item
+		if it.Valid() {
+			got = it.Value()
+		}
+		if got != want {
+			t.Errorf("MoveTo(%d): want %v, got %v", key, want, got)
+		}
+	}
+}
+
+func TestDeleteCompact(t *testing.T) {
+	retained := func(sl *SkipList) (n int) {
+		for _, x := range sl.freelist.freelist {
+			n += cap(x.forward)
+		}
+		return
+	}
+	build := func() *SkipList {
+		sl := New()
+		sl.SetLevelFunc(func() int32 { return 20 })
+		for _, v := range perm(100) {
+			sl.Insert(v)
+		}
+		return sl
+	}
+
+	sl := build()
+	for _, v := range perm(100)[:90] {
+		sl.Delete(v)
+	}
+	if got := retained(sl); got != DefaultFreeListSize*20 {
+		t.Fatalf("Delete: want %d levels retained, got %d", DefaultFreeListSize*20, got)
+	}
+
+	sl = build()
+	it := sl.NewIterator()
+	for _, v := range perm(100)[:90] {
+		if !sl.DeleteCompact(v) {
+			t.Fatalf("DeleteCompact(%v) missed", v)
+		}
+	}
+	if got := retained(sl); got != 0 {
+		t.Fatalf("DeleteCompact: want no levels retained, got %d", got)
+	}
+	if sl.Len() != 10 || sl.DeleteCompact(Int(100)) {
+		t.Fatal("DeleteCompact removed the wrong items")
+	}
+	checkSpans(t, sl)
+	if sl.Search(it.x.item) == nil {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("want a panic using an iterator on a dropped node")
+				}
+			}()
+			it.Value()
+		}()
+	}
+}
+
+func TestValidate(t *testing.T) {
+	sl := New()
+	if err := sl.Validate(); err != nil {
+		t.Fatalf("empty list: %v", err)
+	}
+	for _, v := range perm(200) {
+		sl.Insert(v)
+	}
+	for _, v := range perm(200)[:50] {
+		sl.Delete(v)
+	}
+	sl.SoftDelete(sl.GetByRank(1))
+	if err := sl.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	tall := func() *node {
+		for x := sl.header.forward[0]; x != nil; x = x.forward[0] {
+			if len(x.forward) > 1 && x.forward[1] != nil {
+				return x
+			}
+		}
+		t.Fatal("no node on level 1")
+		return nil
+	}
+	for name, corrupt := range map[string]func() func(){
+		"order": func() func() {
+			x := sl.header.forward[0].forward[0]
+			item := x.item
+			x.item = Int(1000)
+			return func() { x.item = item }
+		},
+		"span": func() func() {
+			x := tall()
+			x.span[1]++
+			return func() { x.span[1]-- }
+		},
+		"skipped link": func() func() {
+			x := tall()
+			y := x.forward[1]
+			x.forward[1], x.span[1] = y.forward[1], x.span[1]+y.span[1]
+			return func() { x.forward[1], x.span[1] = y, x.span[1]-y.span[1] }
+		},
+		"backward": func() func() {
+			x := sl.header.forward[0].forward[0]
+			b := x.backward
+			x.backward = nil
+			return func() { x.backward = b }
+		},
+		"length": func() func() {
+			sl.length++
+			return func() { sl.length-- }
+		},
+		"tombstones": func() func() {
+			sl.tombstones++
+			return func() { sl.tombstones-- }
+		},
+	} {
+		undo := corrupt()
+		if err := sl.Validate(); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: want ErrCorrupt, got %v", name, err)
+		}
+		undo()
+		if err := sl.Validate(); err != nil {
+			t.Fatalf("%s: undo left %v", name, err)
+		}
+	}
+}
+
+func TestPopMinN(t *testing.T) {
+	sl := New()
+	for _, v := range perm(10) {
+		sl.Insert(v)
+	}
+	if got := sl.PopMinN(5); !reflect.DeepEqual(got, rang(5)) {
+		t.Fatalf("want %v, got %v", rang(5), got)
+	}
+	if got := sl.ToInts(); !reflect.DeepEqual(got, []int{5, 6, 7, 8, 9}) {
+		t.Fatalf("remaining: want [5 6 7 8 9], got %v", got)
+	}
+	checkSpans(t, sl)
+	if got := sl.PopMinN(0); got != nil {
+		t.Fatalf("PopMinN(0): want nil, got %v", got)
+	}
+	if got := sl.PopMinN(100); len(got) != 5 || sl.Len() != 0 {
+		t.Fatalf("PopMinN past the end: got %v, %d left", got, sl.Len())
+	}
+	if err := sl.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	w := NewWeighted(func(item Item) float64 { return float64(item.(Int)) })
+	for _, v := range perm(100) {
+		w.Insert(v)
+	}
+	w.PopMinN(30)
+	if w.TotalWeight() != 4950-435 || w.FindByWeight(0) != Int(30) {
+		t.Fatalf("weights not updated: total %v", w.TotalWeight())
+	}
+	if err := w.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDistinctLen(t *testing.T) {
+	sl := NewMultiset()
+	for i := 0; i < 5; i++ {
+		sl.Insert(Int(1))
+	}
+	sl.Insert(Int(3))
+	sl.Insert(Int(2))
+	if sl.DistinctLen() != 3 || sl.Len() != 7 {
+		t.Fatalf("want 3 distinct of 7, got %d of %d", sl.DistinctLen(), sl.Len())
+	}
+	sl.SoftDelete(Int(2))
+	if sl.DistinctLen() != 2 {
+		t.Fatalf("soft-deleted key: want 2 distinct, got %d", sl.DistinctLen())
+	}
+	sl.SoftDelete(Int(1))
+	if sl.DistinctLen() != 2 {
+		t.Fatalf("partly soft-deleted key: want 2 distinct, got %d", sl.DistinctLen())
+	}
+
+	set := New()
+	for _, v := range perm(50) {
+		set.Insert(v)
+	}
+	if set.DistinctLen() != 50 {
+		t.Fatalf("set: want 50 distinct, got %d", set.DistinctLen())
+	}
+}
+
+func TestMultiRange(t *testing.T) {
+	sl := New()
+	for _, v := range perm(100) {
+		sl.Insert(v)
+	}
+	queries := [][2]Item{
+		{Int(-5), Int(2)},
+		{Int(10), Int(14)},
+		{Int(12), Int(13)},
+		{Int(50), Int(49)},
+		{Int(97), Int(200)},
+		{Int(3), Int(4)}, // out of order
+		{Int(150), Int(160)},
+	}
+	got := sl.MultiRange(queries)
+	if len(got) != len(queries) {
+		t.Fatalf("want %d results, got %d", len(queries), len(got))
+	}
+	for i, q := range queries {
+		if want := sl.GetRange(q[0], q[1]); !reflect.DeepEqual(got[i], want) {
+			t.Errorf("query %v: want %v, got %v", q, want, got[i])
+		}
+	}
+	if !reflect.DeepEqual(got[1], rang(15)[10:]) {
+		t.Errorf("want [10..14], got %v", got[1])
+	}
+}
+
+func BenchmarkMultiRange(b *testing.B) {
+	sl := New()
+	for _, item := range perm(benchmarkListSize) {
+		sl.Insert(item)
+	}
+	queries := make([][2]Item, 1000)
+	for i := range queries {
+		begin := i * benchmarkListSize / len(queries)
+		queries[i] = [2]Item{Int(begin), Int(begin + 1)}
+	}
+	b.Run("multi", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			sl.MultiRange(queries)
+		}
+	})
+	b.Run("single", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, q := range queries {
+				sl.GetRange(q[0], q[1])
+			}
+		}
+	})
+}
+
+func TestNewFromSortedCombine(t *testing.T) {
+	sum := func(a, b Item) Item {
+		return kv{a.(kv).key, a.(kv).value + b.(kv).value}
+	}
+	sl := NewFromSortedCombine([]Item{kv{1, 1}, kv{1, 2}, kv{2, 5}}, sum)
+	var got []Item
+	for it := sl.NewIterator(); it.Valid(); it.Next() {
+		got = append(got, it.Value())
+	}
+	if want := []Item{kv{1, 3}, kv{2, 5}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	if err := sl.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if sl := NewFromSorted(rang(100)); !reflect.DeepEqual(sl.ToInts()[:3], []int{0, 1, 2}) || sl.Len() != 100 {
+		t.Fatal("NewFromSorted lost items")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("want a panic on unsorted items")
+		}
+	}()
+	NewFromSortedCombine([]Item{Int(2), Int(1)}, sum)
+}
+
+func TestAvgComparisonsPerSearch(t *testing.T) {
+	sl := New()
+	if sl.AvgComparisonsPerSearch() != 0 {
+		t.Fatal("want 0 before any search")
+	}
+	const n = 10000
+	for _, v := range perm(n) {
+		sl.Insert(v)
+	}
+	sl.Search(Int(0))
+	if sl.Stats().Searches != 0 {
+		t.Fatal("searches should not be counted by default")
+	}
+	sl.SetSearchCounting(true)
+	for _, v := range perm(n) {
+		sl.Search(v)
+	}
+	// (1/P) log_{1/P} n is about 27
+	if avg := sl.AvgComparisonsPerSearch(); avg < 10 || avg > 40 {
+		t.Fatalf("want about 27 comparisons per search, got %.1f", avg)
+	}
+	if sl.Stats().Searches != n {
+		t.Fatalf("want %d searches, got %d", n, sl.Stats().Searches)
+	}
+}
+
+func TestSetP(t *testing.T) {
+	sl := New()
+	const n = 10000
+	for i := 0; i < n; i++ {
+		sl.Insert(Int(i))
+	}
+	sl.SetP(0.75)
+	for i := n; i < 2*n; i++ {
+		sl.Insert(Int(i))
+	}
+	var raised [2]int // nodes above level 1, before and after SetP
+	for x := sl.header.forward[0]; x != nil; x = x.forward[0] {
+		if len(x.forward) > 1 {
+			raised[x.item.(Int)/n]++
+		}
+	}
+	if raised[0] < n*20/100 || raised[0] > n*30/100 {
+		t.Fatalf("P = 0.25: want about 25%% raised, got %d of %d", raised[0], n)
+	}
+	if raised[1] < n*70/100 || raised[1] > n*80/100 {
+		t.Fatalf("P = 0.75: want about 75%% raised, got %d of %d", raised[1], n)
+	}
+	if err := sl.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("want a panic for p = 1")
+		}
+	}()
+	sl.SetP(1)
+}
+
+func TestSetItemCopier(t *testing.T) {
+	sl := New()
+	for _, v := range rand.Perm(10) {
+		sl.Insert(&mutable{v})
+	}
+	copies := 0
+	sl.SetItemCopier(func(item Item) Item {
+		copies++
+		c := *item.(*mutable)
+		return &c
+	})
+	sl.Search(&mutable{5}).(*mutable).key = 100
+	sl.NewIterator().Value().(*mutable).key = 100
+	sl.GetByRank(10).(*mutable).key = -1
+	for _, item := range sl.GetRange(&mutable{0}, &mutable{9}) {
+		item.(*mutable).key = 100
+	}
+	if copies != 13 {
+		t.Fatalf("want 13 copies, got %d", copies)
+	}
+	if !sl.IsSorted() || sl.Search(&mutable{5}) == nil || sl.Search(&mutable{100}) != nil {
+		t.Fatal("mutating a copy changed the stored item")
+	}
+	added, _ := sl.Diff(New())
+	added[3].(*mutable).key = 100
+	first := func(a, b Item) Item { return a }
+	NewMergeCombineIterator(first, sl, sl).Value().(*mutable).key = 100
+	if !sl.IsSorted() || sl.Search(&mutable{3}) == nil || sl.Search(&mutable{0}) == nil {
+		t.Fatal("mutating a Diff or merged item changed the stored item")
+	}
+
+	sl.SetItemCopier(nil)
+	sl.Search(&mutable{5}).(*mutable).key = 100
+	if sl.IsSorted() {
+		t.Fatal("without a copier, Search should hand out the stored item")
+	}
+}
+
+func TestDeleteAndNext(t *testing.T) {
+	sl := New()
+	for _, v := range perm(10) {
+		sl.Insert(v)
+	}
+	if next, ok := sl.DeleteAndNext(Int(5)); !ok || next != Int(6) {
+		t.Fatalf("want 6, true, got %v, %v", next, ok)
+	}
+	if next, ok := sl.DeleteAndNext(Int(5)); ok || next != nil {
+		t.Fatalf("absent item: want nil, false, got %v, %v", next, ok)
+	}
+	sl.SoftDelete(Int(7))
+	if next, ok := sl.DeleteAndNext(Int(6)); !ok || next != Int(8) {
+		t.Fatalf("want the soft-deleted 7 skipped, got %v, %v", next, ok)
+	}
+	if next, ok := sl.DeleteAndNext(Int(9)); !ok || next != nil {
+		t.Fatalf("last item: want nil, true, got %v, %v", next, ok)
+	}
+	if got := sl.ToInts(); !reflect.DeepEqual(got, []int{0, 1, 2, 3, 4, 8}) {
+		t.Fatalf("want [0 1 2 3 4 8], got %v", got)
+	}
+}
+
+func TestRankRangeOf(t *testing.T) {
+	sl := NewMultiset()
+	for _, v := range []int{3, 1, 5, 3, 2, 0, 3} {
+		sl.Insert(Int(v))
+	}
+	if first, last, found := sl.RankRangeOf(Int(3)); first != 4 || last != 6 || !found {
+		t.Fatalf("want 4, 6, true, got %d, %d, %v", first, last, found)
+	}
+	if first, last, found := sl.RankRangeOf(Int(5)); first != 7 || last != 7 || !found {
+		t.Fatalf("single item: want 7, 7, true, got %d, %d, %v", first, last, found)
+	}
+	if first, last, found := sl.RankRangeOf(Int(4)); first != 0 || last != 0 || found {
+		t.Fatalf("absent key: want 0, 0, false, got %d, %d, %v", first, last, found)
+	}
+	for r := 4; r <= 6; r++ {
+		if sl.GetByRank(r) != Int(3) {
+			t.Fatalf("GetByRank(%d): want 3, got %v", r, sl.GetByRank(r))
+		}
+	}
+
+	sl.SoftDelete(Int(1))
+	if first, last, found := sl.RankRangeOf(Int(3)); first != 3 || last != 5 || !found {
+		t.Fatalf("after soft delete: want 3, 5, true, got %d, %d, %v", first, last, found)
+	}
+	if first, _, _ := sl.RankRangeOf(Int(5)); first != sl.Rank(Int(5)) {
+		t.Fatalf("want RankRangeOf to agree with Rank %d, got %d", sl.Rank(Int(5)), first)
+	}
+	sl.SoftDelete(Int(5))
+	if first, last, found := sl.RankRangeOf(Int(5)); first != 0 || last != 0 || found {
+		t.Fatalf("soft deleted key: want 0, 0, false, got %d, %d, %v", first, last, found)
+	}
+}
+
+func TestIncrementalMaintenance(t *testing.T) {
+	const n = 20000
+	r := rand.New(rand.NewSource(1))
+	run := func(maintain bool) *SkipList {
+		sl := NewWithRand(rand.New(rand.NewSource(1)))
+		sl.SetIncrementalMaintenance(maintain)
+		for _, v := range perm(n) {
+			sl.Insert(v)
+		}
+		// adversarial deletes: every node below level 5 goes
+		var short []Item
+		for x := sl.header.forward[0]; x != nil; x = x.forward[0] {
+			if len(x.forward) < 5 {
+				short = append(short, x.item)
+			}
+		}
+		r.Shuffle(len(short), func(i, j int) { short[i], short[j] = short[j], short[i] })
+		for _, v := range short {
+			before := sl.level
+			sl.Delete(v)
+			if before-sl.level > maintainSteps {
+				t.Fatalf("one delete lowered the level from %d to %d", before, sl.level)
+			}
+		}
+		return sl
+	}
+
+	if sl := run(false); sl.level <= sl.ExpectedLevel()+1 {
+		t.Fatalf("control: want the tall nodes left behind, got level %d for %d items", sl.level, sl.Len())
+	}
+	sl := run(true)
+	if sl.level > sl.ExpectedLevel()+2 {
+		t.Fatalf("want level near %d, got %d for %d items", sl.ExpectedLevel(), sl.level, sl.Len())
+	}
+	for i := 0; i < n; i++ {
+		v := Int(r.Intn(2 * n))
+		before := sl.level
+		if r.Intn(2) == 0 {
+			sl.Insert(v)
+		} else {
+			sl.Delete(v)
+		}
+		if before-sl.level > maintainSteps {
+			t.Fatalf("one operation lowered the level from %d to %d", before, sl.level)
+		}
+	}
+	if sl.level > sl.ExpectedLevel()+2 {
+		t.Fatalf("mixed workload: want level near %d, got %d for %d items", sl.ExpectedLevel(), sl.level, sl.Len())
+	}
+	if err := sl.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	w := NewWeighted(func(item Item) float64 { return 1 })
+	w.SetLevelFunc(func() int32 { return 10 })
+	for _, v := range perm(100) {
+		w.Insert(v)
+	}
+	w.SetLevelFunc(nil)
+	w.SetIncrementalMaintenance(true)
+	for i := 0; i < 20; i++ {
+		w.Delete(Int(i))
+	}
+	for i := 0; i < 250; i++ {
+		w.Insert(Int(1000))
+		w.Delete(Int(1000))
+	}
+	if w.level > w.ExpectedLevel()+1 || w.TotalWeight() != 80 || w.FindByWeight(40) != Int(59) {
+		t.Fatalf("weighted list: level %d, total %v", w.level, w.TotalWeight())
+	}
+	if err := w.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewWithComparator(t *testing.T) {
+	type player struct {
+		name  string
+		score int
+	}
+	players := []player{{"ann", 30}, {"bob", 10}, {"cid", 20}}
+	byName := NewWithComparator(func(a, b Item) bool {
+		return a.(Any).V.(player).name < b.(Any).V.(player).name
+	})
+	byScore := NewWithComparator(func(a, b Item) bool {
+		return a.(Any).V.(player).score > b.(Any).V.(player).score
+	})
+	for _, p := range players {
+		byName.Insert(Any{p})
+		byScore.Insert(Any{p})
+	}
+	names := func(sl *SkipList) (s []string) {
+		for it := sl.NewIterator(); it.Valid(); it.Next() {
+			s = append(s, it.Value().(Any).V.(player).name)
+		}
+		return
+	}
+	if got := names(byName); !reflect.DeepEqual(got, []string{"ann", "bob", "cid"}) {
+		t.Fatalf("by name: got %v", got)
+	}
+	if got := names(byScore); !reflect.DeepEqual(got, []string{"ann", "cid", "bob"}) {
+		t.Fatalf("by score: got %v", got)
+	}
+	if item := byName.Search(Any{player{name: "cid"}}); item == nil || item.(Any).V.(player).score != 20 {
+		t.Fatalf("Search by name: got %v", item)
+	}
+	if !byScore.Delete(Any{player{score: 10}}) || byScore.Len() != 2 {
+		t.Fatal("Delete by score missed")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("want a panic ordering Any without a comparator")
+		}
+	}()
+	sl := New()
+	sl.Insert(Any{1})
+	sl.Insert(Any{2})
+}
+
+func TestRank(t *testing.T) {
+	sl := New()
+	for _, v := range perm(1000) {
+		sl.Insert(Int(2 * v.(Int)))
+	}
+	for i := 0; i < 2000; i++ {
+		want := 0
+		if i%2 == 0 {
+			want = i/2 + 1
+		}
+		if got := sl.Rank(Int(i)); got != want {
+			t.Fatalf("Rank(%d): want %d, got %d", i, want, got)
+		}
+		if want > 0 && sl.GetByRank(want) != Int(i) {
+			t.Fatalf("GetByRank(%d): want %d, got %v", want, i, sl.GetByRank(want))
+		}
+	}
+	for _, v := range perm(1000)[:500] {
+		sl.Delete(Int(2 * v.(Int)))
+	}
+	rank := 0
+	for it := sl.NewIterator(); it.Valid(); it.Next() {
+		rank++
+		if got := sl.Rank(it.Value()); got != rank {
+			t.Fatalf("after deletes, Rank(%v): want %d, got %d", it.Value(), rank, got)
+		}
+	}
+	for _, v := range perm(1000)[500:600] {
+		sl.SoftDelete(Int(2 * v.(Int)))
+	}
+	for it := sl.NewIterator(); it.Valid(); it.Next() {
+		if got := sl.GetByRank(sl.Rank(it.Value())); got != it.Value() {
+			t.Fatalf("after soft deletes, GetByRank(Rank(%v)) = %v", it.Value(), got)
+		}
+	}
+
+	m := NewMultiset()
+	for _, v := range []int{0, 1, 1, 2} {
+		m.Insert(Int(v))
+	}
+	m.SoftDelete(Int(1))
+	if got := m.Rank(Int(1)); got != 2 {
+		t.Fatalf("multiset, one soft deleted: want 2, got %d", got)
+	}
+
+	d := NewDescending()
+	for _, v := range perm(10) {
+		d.Insert(v)
+	}
+	if d.Rank(Int(9)) != 1 || d.Rank(Int(0)) != 10 {
+		t.Fatal("descending list: want the greatest item ranked first")
+	}
 }
@@ -0,0 +1,87 @@
+// Package skiplisttest provides helpers for testing code built on skip lists.
+package skiplisttest
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+
+	"github.com/liwnn/skiplist"
+)
+
+// ConcurrentList is a skip list safe for concurrent use, such as a
+// skiplist.ConcurrentSkipList or a SkipList guarded by a lock.
+type ConcurrentList interface {
+	Insert(item skiplist.Item)
+	Delete(item skiplist.Item) bool
+	Search(key skiplist.Item) skiplist.Item
+	Len() int
+	Validate() error
+}
+
+// StressConcurrent runs goroutines goroutines doing ops random inserts,
+// deletes and searches each on sl, which should start empty, then validates
+// it. Every goroutine owns the Int keys equal to its index modulo
+// goroutines and checks after each operation that its own keys are present
+// or absent as its own writes left them, while searching the keys of the
+// others, so that a list losing or resurrecting items is caught. It returns
+// the first violation found, or the error of Validate. Run it with -race to
+// catch unsynchronized access as well.
+func StressConcurrent(sl ConcurrentList, goroutines, ops int) error {
+	const keysPerGoroutine = 64
+	var wg sync.WaitGroup
+	errs := make([]error, goroutines)
+	present := make([]int, goroutines)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(g)))
+			own := make([]bool, keysPerGoroutine)
+			for i := 0; i < ops; i++ {
+				k := r.Intn(keysPerGoroutine)
+				key := skiplist.Int(k*goroutines + g)
+				switch op := r.Intn(4); {
+				case op == 0:
+					sl.Insert(key)
+					own[k] = true
+				case op == 1:
+					if got := sl.Delete(key); got != own[k] {
+						errs[g] = fmt.Errorf("Delete(%v) = %v, want %v", key, got, own[k])
+						return
+					}
+					own[k] = false
+				case op == 2:
+					if found := sl.Search(key) != nil; found != own[k] {
+						errs[g] = fmt.Errorf("Search(%v) found = %v, want %v", key, found, own[k])
+						return
+					}
+				default:
+					other := skiplist.Int(r.Intn(keysPerGoroutine * goroutines))
+					if item := sl.Search(other); item != nil && item != other {
+						errs[g] = fmt.Errorf("Search(%v) = %v", other, item)
+						return
+					}
+				}
+			}
+			for _, ok := range own {
+				if ok {
+					present[g]++
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	want := 0
+	for g, err := range errs {
+		if err != nil {
+			return fmt.Errorf("goroutine %d: %w", g, err)
+		}
+		want += present[g]
+	}
+	if n := sl.Len(); n != want {
+		return fmt.Errorf("Len() = %d, want %d", n, want)
+	}
+	return sl.Validate()
+}
@@ -0,0 +1,78 @@
+package skiplisttest
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/liwnn/skiplist"
+)
+
+// lockedList guards a SkipList with a read-write lock.
+type lockedList struct {
+	mu sync.RWMutex
+	sl *skiplist.SkipList
+}
+
+func (l *lockedList) Insert(item skiplist.Item) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sl.Insert(item)
+}
+
+func (l *lockedList) Delete(item skiplist.Item) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.sl.Delete(item)
+}
+
+func (l *lockedList) Search(key skiplist.Item) skiplist.Item {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.sl.Search(key)
+}
+
+func (l *lockedList) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.sl.Len()
+}
+
+func (l *lockedList) Validate() error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.sl.Validate()
+}
+
+func TestStressConcurrent(t *testing.T) {
+	l := &lockedList{sl: skiplist.New()}
+	if err := StressConcurrent(l, 8, 2000); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// lossyList drops every tenth insert.
+type lossyList struct {
+	lockedList
+	n int
+}
+
+func (l *lossyList) Insert(item skiplist.Item) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.n++; l.n%10 != 0 {
+		l.sl.Insert(item)
+	}
+}
+
+func TestStressConcurrentCatchesLoss(t *testing.T) {
+	l := &lossyList{lockedList: lockedList{sl: skiplist.New()}}
+	if err := StressConcurrent(l, 4, 1000); err == nil {
+		t.Fatal("want an error from a list losing inserts")
+	}
+}
+
+func TestStressConcurrentSkipList(t *testing.T) {
+	if err := StressConcurrent(skiplist.NewConcurrent(), 8, 5000); err != nil {
+		t.Fatal(err)
+	}
+}
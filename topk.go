@@ -0,0 +1,53 @@
+package skiplist
+
+// TopKSet keeps the k greatest items added to it, in a skip list holding no
+// more than k items.
+type TopKSet struct {
+	k  int
+	sl *SkipList
+}
+
+// NewTopKSet creates a TopKSet keeping the k greatest items.
+func NewTopKSet(k int) *TopKSet {
+	if k < 1 {
+		panic("k must be positive")
+	}
+	return &TopKSet{k: k, sl: New()}
+}
+
+// Add adds the item, evicting the least item once more than k are kept. It
+// returns false if the item was rejected for being less than Min of a full
+// set. An item equal to a kept one, Min included, replaces it.
+func (s *TopKSet) Add(item Item) bool {
+	if s.sl.Len() == s.k && s.sl.less(item, s.Min()) {
+		return false
+	}
+	s.sl.Insert(item)
+	if s.sl.Len() > s.k {
+		s.sl.popFront()
+	}
+	return true
+}
+
+// Min returns the least item kept, or nil if the set is empty. Once the set
+// is full, only items not less than Min are admitted.
+func (s *TopKSet) Min() Item {
+	if x := s.sl.header.forward[0]; x != nil {
+		return x.item
+	}
+	return nil
+}
+
+// Items returns the kept items in ascending order.
+func (s *TopKSet) Items() []Item {
+	items := make([]Item, 0, s.sl.Len())
+	for it := s.sl.NewIterator(); it.Valid(); it.Next() {
+		items = append(items, it.Value())
+	}
+	return items
+}
+
+// Len returns the number of items kept, at most k.
+func (s *TopKSet) Len() int {
+	return s.sl.Len()
+}
@@ -0,0 +1,38 @@
+package skiplist
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTopKSet(t *testing.T) {
+	s := NewTopKSet(10)
+	if s.Min() != nil {
+		t.Fatalf("empty set: want nil min, got %v", s.Min())
+	}
+	for _, v := range perm(1000) {
+		s.Add(v)
+	}
+	if s.Len() != 10 {
+		t.Fatalf("len: want 10, got %d", s.Len())
+	}
+	if got, want := s.Items(), rang(1000)[990:]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if s.Min() != Int(990) {
+		t.Fatalf("min: want 990, got %v", s.Min())
+	}
+	if s.Add(Int(500)) {
+		t.Fatal("items below the threshold should be rejected")
+	}
+	if !s.Add(Int(2000)) || s.Min() != Int(991) {
+		t.Fatalf("adding 2000 should evict 990, min is %v", s.Min())
+	}
+
+	p := NewTopKSet(2)
+	p.Add(kv{1, 0})
+	p.Add(kv{2, 0})
+	if !p.Add(kv{1, 1}) || p.Len() != 2 || p.Min() != (kv{1, 1}) {
+		t.Fatalf("an item equal to min should replace it, min is %v", p.Min())
+	}
+}